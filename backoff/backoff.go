@@ -3,8 +3,9 @@
 package backoff
 
 import (
-	"math/rand"
 	"time"
+
+	"github.com/TheThingsNetwork/go-utils/random"
 )
 
 // DefaultConfig is the default backoff configuration
@@ -17,7 +18,7 @@ var (
 	}
 )
 
-// Config defines the parameters for backoff
+// Config defines the parameters for exponential backoff. It is the default Strategy.
 type Config struct {
 	// MaxDelay is the upper bound of backoff delay.
 	MaxDelay time.Duration
@@ -30,6 +31,9 @@ type Config struct {
 
 	// jitter provides a range to randomize backoff delays.
 	Jitter float64
+
+	// Random is the source of randomness for the jitter. If nil, math/rand is used.
+	Random random.Interface
 }
 
 // Backoff returns the delay for the current amount of retries
@@ -47,11 +51,7 @@ func (bc Config) Backoff(retries int) time.Duration {
 	}
 	// Randomize backoff delays so that if a cluster of requests start at
 	// the same time, they won't operate in lockstep.
-	backoff *= 1 + bc.Jitter*(rand.Float64()*2-1)
-	if backoff < 0 {
-		return 0
-	}
-	return time.Duration(backoff)
+	return jitter(bc.Random, backoff, bc.Jitter)
 }
 
 // Backoff returns the delay for the current amount of retries
@@ -0,0 +1,103 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/rate"
+)
+
+// BudgetConfig defines the parameters for a retry Budget
+type BudgetConfig struct {
+	// Ratio is the number of retries that are allowed per successful call in the Window.
+	Ratio float64
+
+	// MinRetries is the number of retries in the Window that is always allowed, even without successful calls.
+	MinRetries uint64
+
+	// Window is the period over which retries and successful calls are counted.
+	Window time.Duration
+
+	// Clock is used to timestamp retries and successful calls. If nil, the RealClock is used.
+	Clock Clock
+}
+
+// DefaultBudgetConfig is the default retry budget configuration
+var DefaultBudgetConfig = BudgetConfig{
+	Ratio:      0.1,
+	MinRetries: 10,
+	Window:     10 * time.Second,
+}
+
+// NewBudget returns a new in-memory retry Budget. Zero values in the configuration are replaced by the values of
+// DefaultBudgetConfig.
+func NewBudget(config BudgetConfig) *Budget {
+	config = config.withDefaults()
+	bucketSize := config.Window / 10
+	if bucketSize <= 0 {
+		bucketSize = time.Second
+	}
+	return NewSharedBudget(rate.NewCounter(bucketSize, config.Window), rate.NewCounter(bucketSize, config.Window), config)
+}
+
+// NewSharedBudget returns a new retry Budget that uses the given counters. Using counters that are shared between
+// instances (see rate.NewRedisCounter) caps the retries fleet-wide. Zero values in the configuration are replaced by
+// the values of DefaultBudgetConfig.
+func NewSharedBudget(retries, successes rate.Counter, config BudgetConfig) *Budget {
+	return &Budget{
+		config:    config.withDefaults(),
+		retries:   retries,
+		successes: successes,
+	}
+}
+
+func (c BudgetConfig) withDefaults() BudgetConfig {
+	if c.Ratio <= 0 {
+		c.Ratio = DefaultBudgetConfig.Ratio
+	}
+	if c.MinRetries == 0 {
+		c.MinRetries = DefaultBudgetConfig.MinRetries
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultBudgetConfig.Window
+	}
+	if c.Clock == nil {
+		c.Clock = RealClock
+	}
+	return c
+}
+
+// Budget limits retries to a ratio of the successful calls, so that when a backend goes down, the retries of all
+// callers that share the Budget don't add up to a retry storm.
+//
+// Callers should call Success after every successful call, and Retry before every retry.
+type Budget struct {
+	config    BudgetConfig
+	retries   rate.Counter
+	successes rate.Counter
+}
+
+// Success records a successful call
+func (b *Budget) Success() error {
+	return b.successes.Add(b.config.Clock.Now(), 1)
+}
+
+// Retry returns true and records the retry if the budget allows a retry, otherwise it returns false.
+// If the counters return an error, the retry is not allowed.
+func (b *Budget) Retry() bool {
+	now := b.config.Clock.Now()
+	successes, err := b.successes.Get(now, b.config.Window)
+	if err != nil {
+		return false
+	}
+	retries, err := b.retries.Get(now, b.config.Window)
+	if err != nil {
+		return false
+	}
+	if float64(retries) >= float64(b.config.MinRetries)+b.config.Ratio*float64(successes) {
+		return false
+	}
+	return b.retries.Add(now, 1) == nil
+}
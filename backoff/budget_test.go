@@ -0,0 +1,56 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestBudget(t *testing.T) {
+	a := New(t)
+
+	clock := NewFakeClock(time.Unix(1500000000, 0))
+	budget := NewBudget(BudgetConfig{
+		Ratio:      0.5,
+		MinRetries: 2,
+		Window:     10 * time.Second,
+		Clock:      clock,
+	})
+
+	a.So(budget.Retry(), ShouldBeTrue)
+	a.So(budget.Retry(), ShouldBeTrue)
+	a.So(budget.Retry(), ShouldBeFalse)
+
+	for i := 0; i < 4; i++ {
+		budget.Success()
+	}
+	a.So(budget.Retry(), ShouldBeTrue)
+	a.So(budget.Retry(), ShouldBeTrue)
+	a.So(budget.Retry(), ShouldBeFalse)
+
+	clock.Advance(15 * time.Second)
+	a.So(budget.Retry(), ShouldBeTrue)
+	a.So(budget.Retry(), ShouldBeTrue)
+	a.So(budget.Retry(), ShouldBeFalse)
+
+	// The budget is shared between callers
+	attempts, err := Retry(context.Background(), testConfig, failTimes(10), WithBudget(budget))
+	a.So(err, ShouldEqual, errTest)
+	a.So(attempts, ShouldEqual, 1)
+}
+
+func TestBudgetDefaults(t *testing.T) {
+	a := New(t)
+
+	budget := NewBudget(BudgetConfig{})
+	a.So(budget.config.Window, ShouldEqual, DefaultBudgetConfig.Window)
+	for i := uint64(0); i < DefaultBudgetConfig.MinRetries; i++ {
+		a.So(budget.Retry(), ShouldBeTrue)
+	}
+	a.So(budget.Retry(), ShouldBeFalse)
+}
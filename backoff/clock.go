@@ -0,0 +1,91 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is used to get the current time and to wait for delays. It can be replaced by a FakeClock in tests, so that
+// retry sequences don't have to wait for real time to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the Clock that uses the time package
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// NewFakeClock returns a new FakeClock that starts at the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.waitersChanged = sync.NewCond(&c.mu)
+	return c
+}
+
+// FakeClock is a Clock that only moves forward when Advance is called
+type FakeClock struct {
+	mu             sync.Mutex
+	now            time.Time
+	waiters        []fakeWaiter
+	waitersChanged *sync.Cond
+}
+
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// Now returns the current time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the current time once the clock has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{until: c.now.Add(d), ch: ch})
+	c.waitersChanged.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward by d and fires all channels returned by After that have expired
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+	c.waitersChanged.Broadcast()
+}
+
+// BlockUntil blocks until at least n goroutines are waiting on channels returned by After
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.waitersChanged.Wait()
+	}
+}
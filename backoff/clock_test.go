@@ -0,0 +1,71 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/pseudorandom"
+	. "github.com/smartystreets/assertions"
+)
+
+func TestFakeClock(t *testing.T) {
+	a := New(t)
+
+	start := time.Unix(0, 0)
+	c := NewFakeClock(start)
+	a.So(c.Now(), ShouldEqual, start)
+
+	immediate := c.After(0)
+	a.So(<-immediate, ShouldEqual, start)
+
+	later := c.After(time.Second)
+	c.BlockUntil(1)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-later:
+		t.Fatal("After fired too early")
+	default:
+	}
+
+	c.Advance(500 * time.Millisecond)
+	a.So(<-later, ShouldEqual, start.Add(time.Second))
+	a.So(c.Now(), ShouldEqual, start.Add(time.Second))
+}
+
+func TestDeterministicRetry(t *testing.T) {
+	a := New(t)
+
+	replay := func() (delays []time.Duration) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		config := DefaultConfig
+		config.Random = pseudorandom.New(42)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			Retry(context.Background(), config, failTimes(5), WithClock(clock))
+		}()
+
+		for i := 0; i < 5; i++ {
+			before := clock.Now()
+			clock.BlockUntil(1)
+			clock.mu.Lock()
+			delay := clock.waiters[0].until.Sub(before)
+			clock.mu.Unlock()
+			clock.Advance(delay)
+			delays = append(delays, delay)
+		}
+		<-done
+		return
+	}
+
+	first := replay()
+	a.So(first, ShouldHaveLength, 5)
+	a.So(first[0], ShouldEqual, DefaultConfig.BaseDelay)
+	a.So(replay(), ShouldResemble, first)
+}
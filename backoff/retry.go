@@ -0,0 +1,114 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"context"
+	"time"
+)
+
+// RetryOption is passed to Retry to configure it
+type RetryOption func(r *retrier)
+
+type retrier struct {
+	maxAttempts    int
+	maxElapsedTime time.Duration
+	retryable      func(error) bool
+	clock          Clock
+	budget         *Budget
+}
+
+// WithMaxAttempts limits the total number of attempts (including the first one).
+// A value of 0 (the default) means no limit.
+func WithMaxAttempts(n int) RetryOption {
+	return func(r *retrier) {
+		r.maxAttempts = n
+	}
+}
+
+// WithMaxElapsedTime stops retrying if the next attempt would start after d has passed since the first attempt.
+// A value of 0 (the default) means no limit.
+func WithMaxElapsedTime(d time.Duration) RetryOption {
+	return func(r *retrier) {
+		r.maxElapsedTime = d
+	}
+}
+
+// WithRetryable sets the func that decides if an error is retryable.
+// By default all errors are retryable.
+func WithRetryable(retryable func(error) bool) RetryOption {
+	return func(r *retrier) {
+		r.retryable = retryable
+	}
+}
+
+// WithClock sets the Clock that is used to measure elapsed time and to wait between attempts.
+// By default the RealClock is used.
+func WithClock(clock Clock) RetryOption {
+	return func(r *retrier) {
+		r.clock = clock
+	}
+}
+
+// WithBudget limits the retries to the given retry Budget, which may be shared with other callers.
+func WithBudget(budget *Budget) RetryOption {
+	return func(r *retrier) {
+		r.budget = budget
+	}
+}
+
+// Retry calls f until it returns nil, f returns an error that is not retryable or the retry budget is exhausted.
+// Between attempts it backs off according to strategy. If ctx is done while backing off, Retry returns immediately.
+// If ctx has a deadline, Retry does not back off beyond that deadline.
+//
+// Retry returns the number of attempts that were made and the error returned by the last attempt. If ctx is done
+// before the first attempt, it returns the context error.
+func Retry(ctx context.Context, strategy Strategy, f func(ctx context.Context) error, opts ...RetryOption) (attempts int, err error) {
+	r := &retrier{
+		retryable: func(error) bool { return true },
+		clock:     RealClock,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	start := r.clock.Now()
+	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if attempts > 0 {
+				return attempts, err
+			}
+			return attempts, ctxErr
+		}
+
+		attempts++
+		err = f(ctx)
+		if err == nil && r.budget != nil {
+			r.budget.Success()
+		}
+		if err == nil || !r.retryable(err) {
+			return attempts, err
+		}
+		if r.maxAttempts > 0 && attempts >= r.maxAttempts {
+			return attempts, err
+		}
+
+		delay := strategy.Backoff(attempts - 1)
+		if r.maxElapsedTime > 0 && r.clock.Now().Sub(start)+delay > r.maxElapsedTime {
+			return attempts, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return attempts, err
+		}
+		if r.budget != nil && !r.budget.Retry() {
+			return attempts, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-r.clock.After(delay):
+		}
+	}
+}
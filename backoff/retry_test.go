@@ -0,0 +1,117 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+var errTest = errors.New("test")
+
+var testConfig = Config{
+	MaxDelay:  10 * time.Millisecond,
+	BaseDelay: time.Millisecond,
+	Factor:    1.6,
+}
+
+func failTimes(n int) func(context.Context) error {
+	return func(context.Context) error {
+		if n > 0 {
+			n--
+			return errTest
+		}
+		return nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	a := New(t)
+
+	{
+		attempts, err := Retry(context.Background(), testConfig, failTimes(0))
+		a.So(err, ShouldBeNil)
+		a.So(attempts, ShouldEqual, 1)
+	}
+
+	{
+		attempts, err := Retry(context.Background(), testConfig, failTimes(3))
+		a.So(err, ShouldBeNil)
+		a.So(attempts, ShouldEqual, 4)
+	}
+
+	{
+		attempts, err := Retry(context.Background(), testConfig, failTimes(10), WithMaxAttempts(3))
+		a.So(err, ShouldEqual, errTest)
+		a.So(attempts, ShouldEqual, 3)
+	}
+
+	{
+		attempts, err := Retry(context.Background(), testConfig, failTimes(10), WithRetryable(func(err error) bool {
+			return err != errTest
+		}))
+		a.So(err, ShouldEqual, errTest)
+		a.So(attempts, ShouldEqual, 1)
+	}
+
+	{
+		start := time.Unix(0, 0)
+		clock := NewFakeClock(start)
+		var (
+			attempts int
+			err      error
+		)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			attempts, err = Retry(context.Background(), testConfig, failTimes(1000), WithMaxElapsedTime(20*time.Millisecond), WithClock(clock))
+		}()
+		// The delays are 1ms, 1.6ms, 2.56ms, 4.096ms and 6.5536ms, the next 10ms would exceed the maximum elapsed time
+		for i := 0; i < 5; i++ {
+			clock.BlockUntil(1)
+			clock.mu.Lock()
+			until := clock.waiters[0].until
+			clock.mu.Unlock()
+			clock.Advance(until.Sub(clock.Now()))
+		}
+		<-done
+		a.So(err, ShouldEqual, errTest)
+		a.So(attempts, ShouldEqual, 6)
+		a.So(clock.Now().Sub(start), ShouldBeLessThanOrEqualTo, 20*time.Millisecond)
+	}
+
+	{
+		// The deadline is checked against the real time, so the bound is wide to avoid flaky results
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := Retry(ctx, testConfig, failTimes(1000))
+		a.So(err, ShouldEqual, errTest)
+		a.So(time.Since(start), ShouldBeLessThan, time.Second)
+	}
+
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+		}()
+		// The error of the last attempt is returned instead of the context error
+		attempts, err := Retry(ctx, testConfig, failTimes(1000))
+		a.So(err, ShouldEqual, errTest)
+		a.So(attempts, ShouldBeGreaterThan, 0)
+	}
+
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts, err := Retry(ctx, testConfig, failTimes(0))
+		a.So(err, ShouldEqual, context.Canceled)
+		a.So(attempts, ShouldEqual, 0)
+	}
+}
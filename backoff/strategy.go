@@ -0,0 +1,150 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/random"
+)
+
+// Strategy determines how long to back off before a retry
+type Strategy interface {
+	// Backoff returns the delay for the current amount of retries
+	Backoff(retries int) time.Duration
+}
+
+// float returns a random number in the range [0,1) from r, or from math/rand if r is nil
+func float(r random.Interface) float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	// Combine two draws that fit in a 32-bit int into the 53 bits of a float64
+	return (float64(r.Intn(1<<26))*(1<<27) + float64(r.Intn(1<<27))) / (1 << 53)
+}
+
+// jitter randomizes the delay d by a factor in the range [1-jitter, 1+jitter]
+func jitter(r random.Interface, d float64, jitter float64) time.Duration {
+	if jitter != 0 {
+		d *= 1 + jitter*(float(r)*2-1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// Constant backs off with the same delay for every retry
+type Constant struct {
+	// Delay is the amount of time to wait before each retry.
+	Delay time.Duration
+
+	// Jitter provides a range to randomize backoff delays.
+	Jitter float64
+
+	// Random is the source of randomness. If nil, math/rand is used.
+	Random random.Interface
+}
+
+// Backoff returns the delay for the current amount of retries
+func (c Constant) Backoff(retries int) time.Duration {
+	return jitter(c.Random, float64(c.Delay), c.Jitter)
+}
+
+// Linear backs off with a delay that increases by a fixed step after each retry
+type Linear struct {
+	// MaxDelay is the upper bound of backoff delay.
+	MaxDelay time.Duration
+
+	// BaseDelay is the amount of time to wait before retrying after the first failure.
+	BaseDelay time.Duration
+
+	// Step is added to the backoff after each retry.
+	Step time.Duration
+
+	// Jitter provides a range to randomize backoff delays.
+	Jitter float64
+
+	// Random is the source of randomness. If nil, math/rand is used.
+	Random random.Interface
+}
+
+// Backoff returns the delay for the current amount of retries
+func (l Linear) Backoff(retries int) time.Duration {
+	backoff := float64(l.BaseDelay) + float64(retries)*float64(l.Step)
+	if max := float64(l.MaxDelay); backoff > max {
+		backoff = max
+	}
+	return jitter(l.Random, backoff, l.Jitter)
+}
+
+// FullJitter is the "Full Jitter" strategy as described by AWS: the delay is chosen uniformly at random between zero
+// and the (capped) exponential backoff. This spreads retries of many clients more evenly than symmetric jitter.
+//
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type FullJitter struct {
+	// MaxDelay is the upper bound of backoff delay.
+	MaxDelay time.Duration
+
+	// BaseDelay is the upper bound of the delay before retrying after the first failure.
+	BaseDelay time.Duration
+
+	// Factor is applied to the upper bound after each retry.
+	Factor float64
+
+	// Random is the source of randomness. If nil, math/rand is used.
+	Random random.Interface
+}
+
+// Backoff returns the delay for the current amount of retries
+func (f FullJitter) Backoff(retries int) time.Duration {
+	backoff, max := float64(f.BaseDelay), float64(f.MaxDelay)
+	for backoff < max && retries > 0 {
+		backoff *= f.Factor
+		retries--
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return time.Duration(float(f.Random) * backoff)
+}
+
+// maxDecorrelatedRetries bounds the number of steps that Decorrelated simulates.
+// The distribution of the delay has long converged by then.
+const maxDecorrelatedRetries = 64
+
+// Decorrelated is the "Decorrelated Jitter" strategy as described by AWS: each delay is chosen uniformly at random
+// between BaseDelay and three times the previous delay, capped at MaxDelay.
+//
+// As a Strategy does not keep state, Decorrelated simulates the previous delays for the given amount of retries.
+//
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type Decorrelated struct {
+	// MaxDelay is the upper bound of backoff delay.
+	MaxDelay time.Duration
+
+	// BaseDelay is the lower bound of backoff delay.
+	BaseDelay time.Duration
+
+	// Random is the source of randomness. If nil, math/rand is used.
+	Random random.Interface
+}
+
+// Backoff returns the delay for the current amount of retries
+func (d Decorrelated) Backoff(retries int) time.Duration {
+	if retries > maxDecorrelatedRetries {
+		retries = maxDecorrelatedRetries
+	}
+	backoff, base, max := float64(d.BaseDelay), float64(d.BaseDelay), float64(d.MaxDelay)
+	for ; retries > 0; retries-- {
+		upper := math.Max(base, math.Min(max, backoff*3))
+		backoff = base + float(d.Random)*(upper-base)
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return time.Duration(backoff)
+}
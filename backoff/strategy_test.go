@@ -0,0 +1,61 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestStrategies(t *testing.T) {
+	a := New(t)
+
+	{
+		s := Constant{Delay: time.Second}
+		a.So(s.Backoff(0), ShouldEqual, time.Second)
+		a.So(s.Backoff(10), ShouldEqual, time.Second)
+	}
+
+	{
+		s := Constant{Delay: time.Second, Jitter: 0.2}
+		for i := 0; i < 100; i++ {
+			a.So(s.Backoff(i), ShouldBeBetweenOrEqual, 800*time.Millisecond, 1200*time.Millisecond)
+		}
+	}
+
+	{
+		s := Linear{BaseDelay: time.Second, Step: 500 * time.Millisecond, MaxDelay: 3 * time.Second}
+		a.So(s.Backoff(0), ShouldEqual, time.Second)
+		a.So(s.Backoff(1), ShouldEqual, 1500*time.Millisecond)
+		a.So(s.Backoff(2), ShouldEqual, 2*time.Second)
+		a.So(s.Backoff(10), ShouldEqual, 3*time.Second)
+	}
+
+	{
+		s := FullJitter{BaseDelay: time.Second, Factor: 2, MaxDelay: 10 * time.Second}
+		for i := 0; i < 100; i++ {
+			a.So(s.Backoff(0), ShouldBeBetweenOrEqual, 0, time.Second)
+			a.So(s.Backoff(2), ShouldBeBetweenOrEqual, 0, 4*time.Second)
+			a.So(s.Backoff(10), ShouldBeBetweenOrEqual, 0, 10*time.Second)
+		}
+	}
+
+	{
+		s := Decorrelated{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+		a.So(s.Backoff(0), ShouldEqual, time.Second)
+		for i := 0; i < 100; i++ {
+			a.So(s.Backoff(1), ShouldBeBetweenOrEqual, time.Second, 3*time.Second)
+			a.So(s.Backoff(2), ShouldBeBetweenOrEqual, time.Second, 9*time.Second)
+			a.So(s.Backoff(1000), ShouldBeBetweenOrEqual, time.Second, 10*time.Second)
+		}
+	}
+
+	{
+		var s Strategy = DefaultConfig
+		a.So(s.Backoff(0), ShouldEqual, DefaultConfig.BaseDelay)
+		a.So(s.Backoff(100), ShouldBeBetweenOrEqual, 96*time.Second, 144*time.Second)
+	}
+}
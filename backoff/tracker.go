@@ -0,0 +1,89 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"sync"
+	"time"
+)
+
+// NewTracker returns a new Tracker that backs off according to strategy, and only resets the number of failures after
+// the connection has been healthy for resetAfter. If clock is nil, the RealClock is used.
+func NewTracker(strategy Strategy, resetAfter time.Duration, clock Clock) *Tracker {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &Tracker{
+		strategy:   strategy,
+		resetAfter: resetAfter,
+		clock:      clock,
+	}
+}
+
+// Tracker keeps track of the failures of a (re)connecting process, so that callers don't have to count retries.
+//
+// A connection that is set up successfully, but fails again within the reset period, keeps backing off as if the
+// setup failed. This prevents hot loops on connections that flap.
+type Tracker struct {
+	strategy   Strategy
+	resetAfter time.Duration
+	clock      Clock
+
+	mu           sync.Mutex
+	failures     int
+	healthySince time.Time
+}
+
+// expire resets the failures if the connection has been healthy for long enough
+func (t *Tracker) expire() {
+	if t.healthySince.IsZero() || t.clock.Now().Sub(t.healthySince) < t.resetAfter {
+		return
+	}
+	t.failures = 0
+}
+
+// Success records that the connection is healthy
+func (t *Tracker) Success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.healthySince.IsZero() {
+		t.healthySince = t.clock.Now()
+	}
+}
+
+// Failure records that the connection failed
+func (t *Tracker) Failure() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	t.healthySince = time.Time{}
+	t.failures++
+}
+
+// Failures returns the number of failures since the last reset
+func (t *Tracker) Failures() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	return t.failures
+}
+
+// Next returns the delay before the next attempt. This is zero if there were no failures since the last reset.
+func (t *Tracker) Next() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	if t.failures == 0 {
+		return 0
+	}
+	return t.strategy.Backoff(t.failures - 1)
+}
+
+// Reset the failures
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = 0
+	t.healthySince = time.Time{}
+}
@@ -0,0 +1,45 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestTracker(t *testing.T) {
+	a := New(t)
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	tracker := NewTracker(Linear{BaseDelay: time.Second, Step: time.Second, MaxDelay: time.Minute}, 10*time.Second, clock)
+
+	a.So(tracker.Next(), ShouldEqual, 0)
+
+	tracker.Failure()
+	a.So(tracker.Next(), ShouldEqual, time.Second)
+	tracker.Failure()
+	a.So(tracker.Next(), ShouldEqual, 2*time.Second)
+
+	// Connection flaps: successful setup, but failure shortly after
+	tracker.Success()
+	clock.Advance(time.Second)
+	a.So(tracker.Failures(), ShouldEqual, 2)
+	tracker.Failure()
+	a.So(tracker.Next(), ShouldEqual, 3*time.Second)
+
+	// Connection is healthy for long enough
+	tracker.Success()
+	clock.Advance(5 * time.Second)
+	tracker.Success() // does not restart the healthy period
+	clock.Advance(5 * time.Second)
+	a.So(tracker.Failures(), ShouldEqual, 0)
+	a.So(tracker.Next(), ShouldEqual, 0)
+	tracker.Failure()
+	a.So(tracker.Next(), ShouldEqual, time.Second)
+
+	tracker.Reset()
+	a.So(tracker.Failures(), ShouldEqual, 0)
+}
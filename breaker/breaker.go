@@ -0,0 +1,252 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+// Package breaker implements a circuit breaker that stops calls to a dependency that is failing.
+//
+// The breaker starts Closed, letting all calls through. When the ratio of failed calls in the past Window exceeds
+// the FailureRatio, the breaker trips and becomes Open, rejecting all calls with a TemporarilyUnavailable error.
+// After the OpenTimeout, the breaker becomes HalfOpen and lets a limited number of trial calls through. If those
+// succeed, the breaker is Closed again, otherwise it returns to Open.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/backoff"
+	"github.com/TheThingsNetwork/go-utils/errors"
+	"github.com/TheThingsNetwork/go-utils/rate"
+)
+
+// State of a Breaker
+type State int
+
+const (
+	// Closed lets all calls through
+	Closed State = iota
+
+	// Open rejects all calls
+	Open
+
+	// HalfOpen lets a limited number of trial calls through
+	HalfOpen
+)
+
+// String implements stringer
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrOpen is returned by an open Breaker
+var ErrOpen = &errors.ErrDescriptor{
+	MessageFormat: "Circuit breaker is open",
+	Type:          errors.TemporarilyUnavailable,
+}
+
+// Config for a Breaker
+type Config struct {
+	// Window is the period over which the failure ratio is calculated
+	Window time.Duration
+
+	// MinRequests is the minimum number of calls in the Window before the breaker can trip
+	MinRequests uint64
+
+	// FailureRatio is the ratio of failed calls at which the breaker trips
+	FailureRatio float64
+
+	// OpenTimeout is the time that the breaker stays open before letting trial calls through
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of trial calls that have to succeed to close the breaker again
+	HalfOpenRequests int
+
+	// FailureTypes are the error types that count as failures. Other errors count as successful calls, as they
+	// indicate that the dependency is able to respond.
+	FailureTypes []errors.Type
+
+	// Clock is used to measure time. If nil, the backoff.RealClock is used.
+	Clock backoff.Clock
+}
+
+// DefaultConfig is the default circuit breaker configuration
+var DefaultConfig = Config{
+	Window:           10 * time.Second,
+	MinRequests:      10,
+	FailureRatio:     0.5,
+	OpenTimeout:      5 * time.Second,
+	HalfOpenRequests: 1,
+	FailureTypes: []errors.Type{
+		errors.TemporarilyUnavailable,
+		errors.Timeout,
+		errors.ResourceExhausted,
+	},
+}
+
+// New returns a new Breaker with the given configuration. Zero values in the configuration are replaced by the
+// values of DefaultConfig.
+func New(config Config) *Breaker {
+	if config.Window <= 0 {
+		config.Window = DefaultConfig.Window
+	}
+	if config.MinRequests == 0 {
+		config.MinRequests = DefaultConfig.MinRequests
+	}
+	if config.FailureRatio <= 0 {
+		config.FailureRatio = DefaultConfig.FailureRatio
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DefaultConfig.OpenTimeout
+	}
+	if config.HalfOpenRequests < 1 {
+		config.HalfOpenRequests = DefaultConfig.HalfOpenRequests
+	}
+	if config.FailureTypes == nil {
+		config.FailureTypes = DefaultConfig.FailureTypes
+	}
+	if config.Clock == nil {
+		config.Clock = backoff.RealClock
+	}
+	b := &Breaker{config: config}
+	b.reset()
+	return b
+}
+
+// Generation identifies the state of a Breaker in which a call was allowed. The result of a call only counts if the
+// Breaker did not change state since the call was allowed, so that calls that were allowed while Closed do not count
+// as trial calls.
+type Generation uint64
+
+// Breaker is a circuit breaker
+type Breaker struct {
+	config Config
+
+	mu         sync.Mutex
+	state      State
+	generation Generation
+	openedAt   time.Time
+	requests   rate.Counter
+	failures   rate.Counter
+	trials     int
+	passed     int
+}
+
+func (b *Breaker) reset() {
+	bucketSize := b.config.Window / 10
+	if bucketSize <= 0 {
+		bucketSize = time.Second
+	}
+	b.requests = rate.NewCounter(bucketSize, b.config.Window)
+	b.failures = rate.NewCounter(bucketSize, b.config.Window)
+	b.trials, b.passed = 0, 0
+}
+
+func (b *Breaker) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	typ := errors.GetType(err)
+	for _, failureType := range b.config.FailureTypes {
+		if typ == failureType {
+			return true
+		}
+	}
+	return false
+}
+
+// setState changes the state and starts a new generation
+func (b *Breaker) setState(state State) {
+	b.state = state
+	b.generation++
+}
+
+// currentState returns the current state, moving from Open to HalfOpen if the OpenTimeout has passed
+func (b *Breaker) currentState(now time.Time) State {
+	if b.state == Open && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.setState(HalfOpen)
+		b.trials, b.passed = 0, 0
+	}
+	return b.state
+}
+
+// State returns the current state of the Breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState(b.config.Clock.Now())
+}
+
+// Allow returns the Generation of the Breaker if a call is allowed, or an ErrOpen error if it is not.
+// Every allowed call must be followed by a call to Done with the returned Generation.
+func (b *Breaker) Allow() (Generation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.currentState(b.config.Clock.Now()) {
+	case Open:
+		return b.generation, ErrOpen.New(nil)
+	case HalfOpen:
+		if b.trials >= b.config.HalfOpenRequests {
+			return b.generation, ErrOpen.New(nil)
+		}
+		b.trials++
+	}
+	return b.generation, nil
+}
+
+// Done records the result of a call that was allowed in the given Generation. The result is ignored if the Breaker
+// changed state since the call was allowed.
+func (b *Breaker) Done(generation Generation, err error) {
+	failure := b.isFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.config.Clock.Now()
+	state := b.currentState(now)
+	if generation != b.generation {
+		return
+	}
+	switch state {
+	case Closed:
+		b.requests.Add(now, 1)
+		if !failure {
+			return
+		}
+		b.failures.Add(now, 1)
+		requests, _ := b.requests.Get(now, b.config.Window)
+		failures, _ := b.failures.Get(now, b.config.Window)
+		if requests >= b.config.MinRequests && float64(failures) >= b.config.FailureRatio*float64(requests) {
+			b.setState(Open)
+			b.openedAt = now
+		}
+	case HalfOpen:
+		if failure {
+			b.setState(Open)
+			b.openedAt = now
+			return
+		}
+		b.passed++
+		if b.passed >= b.config.HalfOpenRequests {
+			b.setState(Closed)
+			b.reset()
+		}
+	}
+}
+
+// Call calls f if the Breaker allows it, and records the result
+func (b *Breaker) Call(f func() error) error {
+	generation, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = f()
+	b.Done(generation, err)
+	return err
+}
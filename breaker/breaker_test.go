@@ -0,0 +1,120 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/backoff"
+	"github.com/TheThingsNetwork/go-utils/errors"
+	"github.com/smartystreets/assertions"
+)
+
+var (
+	errUnavailable = &errors.ErrDescriptor{MessageFormat: "Unavailable", Type: errors.TemporarilyUnavailable}
+	errInvalid     = &errors.ErrDescriptor{MessageFormat: "Invalid", Type: errors.InvalidArgument}
+)
+
+func TestBreaker(t *testing.T) {
+	a := assertions.New(t)
+
+	clock := backoff.NewFakeClock(time.Now())
+	config := DefaultConfig
+	config.Clock = clock
+	config.MinRequests = 4
+	config.HalfOpenRequests = 2
+	b := New(config)
+
+	call := func(err error) error {
+		return b.Call(func() error { return err })
+	}
+
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+
+	// Errors of other types don't count as failures
+	for i := 0; i < 10; i++ {
+		a.So(call(errInvalid.New(nil)), assertions.ShouldNotBeNil)
+	}
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+
+	// Failures older than the window are forgotten
+	clock.Advance(config.Window + config.Window/2)
+	a.So(call(nil), assertions.ShouldBeNil)
+	a.So(call(errUnavailable.New(nil)), assertions.ShouldNotBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+	a.So(call(errUnavailable.New(nil)), assertions.ShouldNotBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+	a.So(call(errUnavailable.New(nil)), assertions.ShouldNotBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Open)
+
+	var called bool
+	err := b.Call(func() error { called = true; return nil })
+	a.So(called, assertions.ShouldBeFalse)
+	a.So(errors.GetType(err), assertions.ShouldEqual, errors.TemporarilyUnavailable)
+
+	// Failed trial
+	clock.Advance(config.OpenTimeout)
+	a.So(b.State(), assertions.ShouldEqual, HalfOpen)
+	a.So(call(errUnavailable.New(nil)), assertions.ShouldNotBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Open)
+
+	// Successful trials
+	clock.Advance(config.OpenTimeout)
+	g1, err := b.Allow()
+	a.So(err, assertions.ShouldBeNil)
+	g2, err := b.Allow()
+	a.So(err, assertions.ShouldBeNil)
+	_, err = b.Allow()
+	a.So(err, assertions.ShouldNotBeNil)
+	b.Done(g1, nil)
+	a.So(b.State(), assertions.ShouldEqual, HalfOpen)
+	b.Done(g2, nil)
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+}
+
+func TestBreakerLateResults(t *testing.T) {
+	a := assertions.New(t)
+
+	clock := backoff.NewFakeClock(time.Now())
+	config := DefaultConfig
+	config.Clock = clock
+	config.MinRequests = 2
+	config.HalfOpenRequests = 2
+	b := New(config)
+
+	// A call that is allowed while Closed and takes long
+	slow, err := b.Allow()
+	a.So(err, assertions.ShouldBeNil)
+
+	a.So(b.Call(func() error { return errUnavailable.New(nil) }), assertions.ShouldNotBeNil)
+	a.So(b.Call(func() error { return errUnavailable.New(nil) }), assertions.ShouldNotBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Open)
+
+	clock.Advance(config.OpenTimeout)
+	trial, err := b.Allow()
+	a.So(err, assertions.ShouldBeNil)
+
+	// The late result is not counted as a trial call
+	b.Done(slow, nil)
+	a.So(b.State(), assertions.ShouldEqual, HalfOpen)
+	b.Done(trial, nil)
+	a.So(b.State(), assertions.ShouldEqual, HalfOpen)
+
+	// And a late failure does not open the Breaker again
+	b.Done(slow, errUnavailable.New(nil))
+	a.So(b.State(), assertions.ShouldEqual, HalfOpen)
+	a.So(b.Call(func() error { return nil }), assertions.ShouldBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+}
+
+func TestBreakerDefaults(t *testing.T) {
+	a := assertions.New(t)
+
+	b := New(Config{})
+	a.So(b.config.Window, assertions.ShouldEqual, DefaultConfig.Window)
+	a.So(b.config.FailureTypes, assertions.ShouldResemble, DefaultConfig.FailureTypes)
+	a.So(b.Call(func() error { return errUnavailable.New(nil) }), assertions.ShouldNotBeNil)
+	a.So(b.State(), assertions.ShouldEqual, Closed)
+}
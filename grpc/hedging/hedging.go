@@ -0,0 +1,196 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+// Package hedging implements a gRPC interceptor that sends hedged unary RPCs.
+//
+// If a call did not return after a delay based on the latencies of earlier calls, a second copy of the call is sent.
+// The first successful response is used and the other calls are canceled. This reduces tail latency for calls to
+// replicated backends, at the cost of extra load. Only use it for idempotent methods.
+package hedging
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/backoff"
+	"github.com/TheThingsNetwork/go-utils/grpc/rpclog"
+	"github.com/TheThingsNetwork/go-utils/log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Settings for hedging calls to a method
+type Settings struct {
+	// MaxAttempts is the total number of copies of a call, including the original call.
+	MaxAttempts int
+
+	// Percentile (0-100) of the latencies of earlier calls after which the first hedged call is sent.
+	Percentile float64
+
+	// Samples is the number of latencies of earlier calls that is kept.
+	Samples int
+
+	// MinSamples is the number of latencies that is needed before the Percentile is used.
+	MinSamples int
+
+	// Delay is used for the first hedged call until there are MinSamples latencies, and for the delay between
+	// subsequent hedged calls.
+	Delay backoff.Strategy
+
+	// NonFatalCodes are the codes that do not stop hedging. If an attempt fails with one of these codes,
+	// the next hedged call is sent immediately. Any other error is returned immediately.
+	NonFatalCodes []codes.Code
+}
+
+// DefaultSettings for hedging
+var DefaultSettings = Settings{
+	MaxAttempts: 2,
+	Percentile:  95,
+	Samples:     1000,
+	MinSamples:  100,
+	Delay: backoff.Constant{
+		Delay: 100 * time.Millisecond,
+	},
+	NonFatalCodes: []codes.Code{
+		codes.Unavailable,
+	},
+}
+
+type method struct {
+	Settings
+	latencies *latencies
+}
+
+func (m *method) delay(attempt int) time.Duration {
+	if attempt == 1 {
+		if delay, ok := m.latencies.percentile(m.Percentile, m.MinSamples); ok {
+			return delay
+		}
+	}
+	return m.Delay.Backoff(attempt - 1)
+}
+
+func (m *method) isFatal(err error) bool {
+	code := grpc.Code(err)
+	for _, nonFatal := range m.NonFatalCodes {
+		if code == nonFatal {
+			return false
+		}
+	}
+	return true
+}
+
+type result struct {
+	attempt  int
+	reply    interface{}
+	err      error
+	duration time.Duration // of the attempt
+}
+
+// newReply returns a new reply of the same type as reply, or reply itself if it is not a pointer
+func newReply(reply interface{}) interface{} {
+	if v := reflect.ValueOf(reply); !v.IsValid() || v.Kind() != reflect.Ptr || v.IsNil() {
+		return reply
+	}
+	return reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+}
+
+// setReply copies the reply of the winning attempt to reply
+func setReply(reply, attemptReply interface{}) {
+	if v := reflect.ValueOf(reply); !v.IsValid() || v.Kind() != reflect.Ptr || v.IsNil() {
+		return
+	}
+	reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(attemptReply).Elem())
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// UnaryClientInterceptor hedges calls to the given methods (full method names: /package.Service/Method).
+// Calls to other methods are passed through.
+func UnaryClientInterceptor(methods map[string]Settings) grpc.UnaryClientInterceptor {
+	hedged := make(map[string]*method, len(methods))
+	for name, settings := range methods {
+		if settings.Delay == nil {
+			settings.Delay = DefaultSettings.Delay
+		}
+		hedged[name] = &method{Settings: settings, latencies: newLatencies(settings.Samples)}
+	}
+	return func(ctx context.Context, name string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		m, ok := hedged[name]
+		if !ok || m.MaxAttempts < 2 {
+			return invoker(ctx, name, req, reply, cc, opts...)
+		}
+		return m.invoke(ctx, name, req, reply, cc, invoker, opts...)
+	}
+}
+
+// invoke sends hedged calls until one of them succeeds
+func (m *method) invoke(ctx context.Context, name string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	log := log.Get().WithField("method", name).WithFields(rpclog.FieldsFromOutgoingContext(ctx))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, m.MaxAttempts)
+	var attempts int
+	send := func() {
+		attempt := attempts
+		attempts++
+		go func() {
+			start := time.Now()
+			attemptReply := newReply(reply)
+			err := invoker(ctx, name, req, attemptReply, cc, opts...)
+			results <- result{attempt: attempt, reply: attemptReply, err: err, duration: time.Since(start)}
+		}()
+	}
+
+	send()
+	hedge := time.NewTimer(m.delay(attempts))
+	defer hedge.Stop()
+
+	var done int
+	for {
+		select {
+		case <-hedge.C:
+			if attempts < m.MaxAttempts {
+				log.WithField("attempt", attempts).Debug("hedging: sending hedged call")
+				send()
+				resetTimer(hedge, m.delay(attempts))
+			}
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case res := <-results:
+			done++
+			if res.err == nil {
+				// Only the winning attempt is sampled, the others were canceled before they finished
+				m.latencies.add(res.duration)
+				setReply(reply, res.reply)
+				log.WithField("attempt", res.attempt).WithField("duration", res.duration).Debug("hedging: attempt won")
+				return nil
+			}
+			err = res.err
+			if m.isFatal(err) || ctx.Err() != nil {
+				return err
+			}
+			if attempts < m.MaxAttempts {
+				log.WithField("attempt", attempts).WithField("error", grpc.ErrorDesc(err)).Debug("hedging: sending hedged call after failure")
+				send()
+				resetTimer(hedge, m.delay(attempts))
+				continue
+			}
+			if done == attempts {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package hedging
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/backoff"
+	. "github.com/TheThingsNetwork/go-utils/grpc/internal/test"
+	. "github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// slowInvoker returns an invoker that responds after the given latencies (per attempt)
+func slowInvoker(calls *int32, canceled *int32, latencies ...time.Duration) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempt := atomic.AddInt32(calls, 1) - 1
+		select {
+		case <-time.After(latencies[attempt]):
+			reply.(*Bar).Bar = method
+			return nil
+		case <-ctx.Done():
+			atomic.AddInt32(canceled, 1)
+			return grpc.Errorf(codes.Canceled, "canceled")
+		}
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	a := New(t)
+	l := newLatencies(10)
+	_, ok := l.percentile(50, 1)
+	a.So(ok, ShouldBeFalse)
+	for i := 20; i > 0; i-- {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	p, ok := l.percentile(50, 10)
+	a.So(ok, ShouldBeTrue)
+	a.So(p, ShouldEqual, 6*time.Millisecond)
+	p, _ = l.percentile(100, 10)
+	a.So(p, ShouldEqual, 10*time.Millisecond)
+}
+
+func TestHedgedLatencies(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultSettings
+	settings.Delay = backoff.Constant{Delay: 10 * time.Millisecond}
+	m := &method{Settings: settings, latencies: newLatencies(10)}
+
+	var calls, canceled int32
+	err := m.invoke(context.Background(), "/test.Test/Get", nil, new(Bar), nil, slowInvoker(&calls, &canceled, 50*time.Millisecond, time.Millisecond))
+	a.So(err, ShouldBeNil)
+
+	// Only the winning attempt is sampled, from the time at which it was sent
+	a.So(m.latencies.count(), ShouldEqual, 1)
+	a.So(m.latencies.samples[0], ShouldBeGreaterThanOrEqualTo, time.Millisecond)
+	a.So(m.latencies.samples[0], ShouldBeLessThan, 10*time.Millisecond)
+
+	// Failed and canceled calls are not sampled
+	ctx, cancel := context.WithCancel(context.Background())
+	var canceledCalls, canceledAttempts int32
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+	err = m.invoke(ctx, "/test.Test/Get", nil, new(Bar), nil, slowInvoker(&canceledCalls, &canceledAttempts, time.Second, time.Second))
+	a.So(grpc.Code(err), ShouldEqual, codes.Canceled)
+	a.So(m.latencies.count(), ShouldEqual, 1)
+}
+
+func TestHedgedContextDone(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultSettings
+	settings.Delay = backoff.Constant{Delay: time.Hour}
+	m := &method{Settings: settings, latencies: newLatencies(10)}
+
+	// An invoker that does not return when the context is done
+	block := make(chan struct{})
+	defer close(block)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		<-block
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	err := m.invoke(ctx, "/test.Test/Get", nil, new(Bar), nil, invoker)
+	a.So(grpc.Code(err), ShouldEqual, codes.DeadlineExceeded)
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultSettings
+	settings.MinSamples = 2
+	settings.Delay = backoff.Constant{Delay: 10 * time.Millisecond}
+	interceptor := UnaryClientInterceptor(map[string]Settings{
+		"/test.Test/Get": settings,
+	})
+
+	{
+		var calls, canceled int32
+		bar := new(Bar)
+		err := interceptor(context.Background(), "/test.Test/Get", nil, bar, nil, slowInvoker(&calls, &canceled, 50*time.Millisecond, time.Millisecond))
+		a.So(err, ShouldBeNil)
+		a.So(bar.Bar, ShouldEqual, "/test.Test/Get")
+		a.So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		time.Sleep(time.Millisecond)
+		a.So(atomic.LoadInt32(&canceled), ShouldEqual, 1)
+	}
+
+	{
+		var calls, canceled int32
+		err := interceptor(context.Background(), "/test.Test/Get", nil, new(Bar), nil, slowInvoker(&calls, &canceled, time.Millisecond))
+		a.So(err, ShouldBeNil)
+		a.So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+	}
+
+	// After enough samples, the hedging delay is based on the observed latencies of the winning attempts, which took
+	// 1ms. The hedged call is sent after that, and wins before the first attempt responds.
+	{
+		var calls, canceled int32
+		start := time.Now()
+		err := interceptor(context.Background(), "/test.Test/Get", nil, new(Bar), nil, slowInvoker(&calls, &canceled, 50*time.Millisecond, time.Millisecond))
+		a.So(err, ShouldBeNil)
+		a.So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		a.So(time.Since(start), ShouldBeLessThan, 10*time.Millisecond)
+	}
+
+	// Calls without a reply can be hedged
+	{
+		var calls int32
+		err := interceptor(context.Background(), "/test.Test/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-ctx.Done()
+				return grpc.Errorf(codes.Canceled, "canceled")
+			}
+			return nil
+		})
+		a.So(err, ShouldBeNil)
+		a.So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+	}
+
+	// Fatal errors are returned immediately
+	{
+		var calls int32
+		err := interceptor(context.Background(), "/test.Test/Get", nil, new(Bar), nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			atomic.AddInt32(&calls, 1)
+			return grpc.Errorf(codes.InvalidArgument, "invalid")
+		})
+		a.So(grpc.Code(err), ShouldEqual, codes.InvalidArgument)
+		a.So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+	}
+
+	// Other methods are not hedged
+	{
+		var calls, canceled int32
+		err := interceptor(context.Background(), "/test.Test/Other", nil, new(Bar), nil, slowInvoker(&calls, &canceled, 20*time.Millisecond))
+		a.So(err, ShouldBeNil)
+		a.So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package hedging
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencies keeps the most recent latencies of a method
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencies(size int) *latencies {
+	if size < 1 {
+		size = 1
+	}
+	return &latencies{samples: make([]time.Duration, size)}
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+func (l *latencies) count() int {
+	if l.full {
+		return len(l.samples)
+	}
+	return l.next
+}
+
+// percentile returns the latency at the given percentile (0-100), or false if there are less than min samples
+func (l *latencies) percentile(p float64, min int) (time.Duration, bool) {
+	l.mu.Lock()
+	n := l.count()
+	if n == 0 || n < min {
+		l.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.samples[:n])
+	l.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p / 100 * float64(n))
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i], true
+}
@@ -21,6 +21,33 @@ import (
 type Settings struct {
 	RetryableCodes []codes.Code
 	Backoff        backoff.Config
+
+	// Strategy overrides Backoff if set
+	Strategy backoff.Strategy
+
+	// Clock is used for backing off. If nil, the backoff.RealClock is used.
+	Clock backoff.Clock
+
+	// ResetAfter is the duration that a restarted stream has to stay healthy before the backoff is reset
+	ResetAfter time.Duration
+
+	// Budget limits the restarts, it can be shared between streams. If the budget is exhausted,
+	// the stream is not restarted and the error is returned.
+	Budget *backoff.Budget
+}
+
+func (s Settings) strategy() backoff.Strategy {
+	if s.Strategy != nil {
+		return s.Strategy
+	}
+	return s.Backoff
+}
+
+func (s Settings) clock() backoff.Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+	return backoff.RealClock
 }
 
 // DefaultSettings for Interceptor
@@ -32,7 +59,8 @@ var DefaultSettings = Settings{
 		codes.Aborted,
 		codes.Unavailable,
 	},
-	Backoff: backoff.DefaultConfig,
+	Backoff:    backoff.DefaultConfig,
+	ResetAfter: 10 * time.Second,
 }
 
 type restartingStream struct {
@@ -48,24 +76,32 @@ type restartingStream struct {
 	argument interface{}
 
 	retryableCodes []codes.Code
-	backoff        backoff.Config
-	retries        int
+	clock          backoff.Clock
+	tracker        *backoff.Tracker
+	budget         *backoff.Budget
 
 	sync.RWMutex
 	cancel context.CancelFunc
 	grpc.ClientStream
 }
 
-func (s *restartingStream) start() (err error) {
+func (s *restartingStream) start() error {
 	s.Lock()
 	defer s.Unlock()
+	return s.connect()
+}
 
+// connect sets up the stream, backing off after failures. The stream must be locked.
+func (s *restartingStream) connect() (err error) {
 stream:
 	for {
-		if s.retries >= 0 {
-			backoff := s.backoff.Backoff(s.retries)
-			s.log.WithField("duration", backoff).Debug("restartstream: backing off")
-			time.Sleep(backoff)
+		if backoff := s.tracker.Next(); backoff > 0 {
+			s.log.WithField("duration", backoff).WithField("failures", s.tracker.Failures()).Debug("restartstream: backing off")
+			select {
+			case <-s.clock.After(backoff):
+			case <-s.ctx.Done():
+				return s.ctx.Err()
+			}
 		}
 		if s.cancel != nil {
 			s.cancel()
@@ -75,18 +111,22 @@ stream:
 		ctx, s.cancel = context.WithCancel(s.ctx)
 		s.ClientStream, err = s.streamer(ctx, s.desc, s.cc, s.method, s.opts...)
 		if err == nil {
-			s.retries = 0
+			s.tracker.Success()
+			if s.budget != nil {
+				s.budget.Success()
+			}
 			break
 		}
 		s.log.WithField("error", grpc.ErrorDesc(err)).Debug("restartstream: setup unsuccessful")
-		for _, retryable := range s.retryableCodes {
-			if grpc.Code(err) == retryable {
-				backoff := s.backoff.Backoff(s.retries)
-				s.log.WithField("duration", backoff).Debug("restartstream: backing off Start")
-				time.Sleep(backoff)
-				s.retries++
-				continue stream
+		if s.ctx.Err() != nil {
+			return err // context canceled
+		}
+		if s.isRetryable(err) {
+			if !s.retry() {
+				return err
 			}
+			s.tracker.Failure()
+			continue stream
 		}
 		return err
 	}
@@ -105,22 +145,57 @@ stream:
 	return
 }
 
+func (s *restartingStream) isRetryable(err error) bool {
+	for _, retryable := range s.retryableCodes {
+		if grpc.Code(err) == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// restart restarts the stream after it failed with err, unless it was already restarted by SendMsg or RecvMsg. It
+// returns err if the error is not retryable or the retry budget is exhausted.
+func (s *restartingStream) restart(failed grpc.ClientStream, err error) error {
+	if !s.isRetryable(err) {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.ClientStream != failed {
+		return nil
+	}
+	if !s.retry() {
+		return err
+	}
+	s.log.WithField("error", grpc.ErrorDesc(err)).Debug("restartstream: restarting")
+	s.tracker.Failure()
+	return s.connect()
+}
+
+// retry returns true if the retry budget allows restarting the stream
+func (s *restartingStream) retry() bool {
+	if s.budget == nil || s.budget.Retry() {
+		return true
+	}
+	s.log.Debug("restartstream: retry budget exhausted")
+	return false
+}
+
 // ErrStreamClosed is returned when trying to call SendMsg or RecvMsg on a closed stream
 var ErrStreamClosed = errors.New("grpc: stream closed")
 
 func (s *restartingStream) SendMsg(m interface{}) error {
-	var retries int
-send:
 	for {
-		s.RLock()
+		s.Lock()
 		stream := s.ClientStream
-		s.RUnlock()
-		if stream == nil {
-			return ErrStreamClosed
-		}
 		if !s.desc.ClientStreams {
 			s.argument = m
 		}
+		s.Unlock()
+		if stream == nil {
+			return ErrStreamClosed
+		}
 
 		err := stream.SendMsg(m) // blocking
 		if err == nil {
@@ -133,23 +208,16 @@ send:
 			return err // context canceled
 		}
 
-		for _, retryable := range s.retryableCodes {
-			if grpc.Code(err) == retryable {
-				backoff := s.backoff.Backoff(retries)
-				s.log.WithField("error", grpc.ErrorDesc(err)).WithField("duration", backoff).Debug("restartstream: backing off SendMsg")
-				time.Sleep(backoff)
-				retries++
-				continue send
-			}
+		if err := s.restart(stream, err); err != nil {
+			return err
+		}
+		if !s.desc.ClientStreams {
+			return nil // the argument is sent when the stream is restarted
 		}
-
-		return err
 	}
 }
 
 func (s *restartingStream) RecvMsg(m interface{}) (err error) {
-	var retries int
-recv:
 	for {
 		s.RLock()
 		stream := s.ClientStream
@@ -173,20 +241,9 @@ recv:
 			return err // eof
 		}
 
-		for _, retryable := range s.retryableCodes {
-			if grpc.Code(err) == retryable {
-				if err := s.start(); err != nil {
-					return err
-				}
-				backoff := s.backoff.Backoff(retries)
-				s.log.WithField("error", grpc.ErrorDesc(err)).WithField("duration", backoff).Debug("restartstream: backing off RecvMsg")
-				time.Sleep(backoff)
-				retries++
-				continue recv
-			}
+		if err := s.restart(stream, err); err != nil {
+			return err
 		}
-
-		return err
 	}
 }
 
@@ -210,8 +267,9 @@ func Interceptor(settings Settings) grpc.StreamClientInterceptor {
 			opts:     opts,
 
 			retryableCodes: settings.RetryableCodes,
-			backoff:        settings.Backoff,
-			retries:        -1,
+			clock:          settings.clock(),
+			tracker:        backoff.NewTracker(settings.strategy(), settings.ResetAfter, settings.clock()),
+			budget:         settings.Budget,
 		}
 
 		err = s.start()
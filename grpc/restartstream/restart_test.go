@@ -9,17 +9,43 @@ import (
 	"testing"
 	"time"
 
+	"github.com/TheThingsNetwork/go-utils/backoff"
 	. "github.com/TheThingsNetwork/go-utils/grpc/internal/test"
 	"github.com/TheThingsNetwork/go-utils/log"
 	"github.com/TheThingsNetwork/go-utils/log/test"
+	"github.com/TheThingsNetwork/go-utils/pseudorandom"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	. "github.com/smartystreets/assertions"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const sleepTime = 20 * time.Millisecond
 
+// advancingClock returns a FakeClock that is advanced by step whenever a stream backs off, so that restarts do not
+// depend on the real time
+func advancingClock(t *testing.T, step time.Duration) *backoff.FakeClock {
+	clock := backoff.NewFakeClock(time.Now())
+	stop := make(chan struct{})
+	go func() {
+		for {
+			clock.BlockUntil(1)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			clock.Advance(step)
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		clock.After(step) // wakes up the goroutine if no stream is backing off
+	})
+	return clock
+}
+
 func TestReconnect(t *testing.T) {
 	a := New(t)
 
@@ -47,6 +73,8 @@ func TestReconnect(t *testing.T) {
 	settings := DefaultSettings
 	settings.RetryableCodes = append(settings.RetryableCodes, codes.InvalidArgument)
 	settings.Backoff.BaseDelay = 10 * time.Millisecond
+	settings.Backoff.Random = pseudorandom.New(42)
+	settings.Clock = advancingClock(t, settings.Backoff.MaxDelay)
 	conn, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(Interceptor(settings), breakStream.Interceptor)))
 	if err != nil {
 		t.Fatalf("Dial(%q) = %v", addr, err)
@@ -250,3 +278,37 @@ func TestReconnect(t *testing.T) {
 	}
 
 }
+
+// brokenStream is a ClientStream that fails to send
+type brokenStream struct {
+	grpc.ClientStream
+}
+
+func (brokenStream) Context() context.Context { return context.Background() }
+func (brokenStream) CloseSend() error         { return nil }
+func (brokenStream) SendMsg(interface{}) error {
+	return status.Error(codes.Unavailable, "broken")
+}
+
+func TestSendMsgBudget(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultSettings
+	settings.Backoff.Random = pseudorandom.New(42)
+	settings.Clock = advancingClock(t, settings.Backoff.MaxDelay)
+	settings.Budget = backoff.NewBudget(backoff.BudgetConfig{Ratio: 0.1, MinRetries: 1, Clock: settings.Clock})
+
+	var started int
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		started++
+		return brokenStream{}, nil
+	}
+	desc := &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}
+	stream, err := Interceptor(settings)(context.Background(), desc, nil, "/test", streamer)
+	a.So(err, ShouldBeNil)
+
+	// The stream is restarted until the retry budget of 1 retry plus 0.1 per started stream is exhausted
+	err = stream.SendMsg(&Foo{Foo: "ok"})
+	a.So(status.Code(err), ShouldEqual, codes.Unavailable)
+	a.So(started, ShouldEqual, 3)
+}
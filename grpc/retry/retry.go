@@ -0,0 +1,97 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+// Package retry implements a gRPC interceptor that retries unary RPCs.
+package retry
+
+import (
+	"github.com/TheThingsNetwork/go-utils/backoff"
+	"github.com/TheThingsNetwork/go-utils/grpc/restartstream"
+	"github.com/TheThingsNetwork/go-utils/log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Settings for UnaryClientInterceptor
+type Settings struct {
+	// Settings are the default retryable codes and backoff for all methods
+	restartstream.Settings
+
+	// MaxAttempts limits the total number of attempts of a call. A value of 0 means no limit.
+	MaxAttempts int
+
+	// Idempotent are the full method names (/package.Service/Method) that can safely be retried.
+	// Calls to other methods are never retried.
+	Idempotent []string
+
+	// Methods overrides the Settings for specific methods
+	Methods map[string]restartstream.Settings
+}
+
+// DefaultSettings for UnaryClientInterceptor
+var DefaultSettings = Settings{
+	Settings:    restartstream.DefaultSettings,
+	MaxAttempts: 5,
+}
+
+func strategy(settings restartstream.Settings) backoff.Strategy {
+	if settings.Strategy != nil {
+		return settings.Strategy
+	}
+	return settings.Backoff
+}
+
+// UnaryClientInterceptor retries unary RPCs to idempotent methods that fail with a retryable code.
+// It does not retry after the context of the call is done, and does not back off beyond its deadline.
+func UnaryClientInterceptor(settings Settings) grpc.UnaryClientInterceptor {
+	idempotent := make(map[string]bool, len(settings.Idempotent))
+	for _, method := range settings.Idempotent {
+		idempotent[method] = true
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		if !idempotent[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		methodSettings := settings.Settings
+		if override, ok := settings.Methods[method]; ok {
+			methodSettings = override
+		}
+
+		retryOpts := []backoff.RetryOption{
+			backoff.WithMaxAttempts(settings.MaxAttempts),
+			backoff.WithRetryable(func(err error) bool {
+				if ctx.Err() != nil {
+					return false
+				}
+				for _, retryable := range methodSettings.RetryableCodes {
+					if grpc.Code(err) == retryable {
+						return true
+					}
+				}
+				return false
+			}),
+		}
+		if methodSettings.Clock != nil {
+			retryOpts = append(retryOpts, backoff.WithClock(methodSettings.Clock))
+		}
+		if methodSettings.Budget != nil {
+			retryOpts = append(retryOpts, backoff.WithBudget(methodSettings.Budget))
+		}
+
+		log := log.Get().WithField("method", method)
+		_, err = backoff.Retry(ctx, strategy(methodSettings), func(ctx context.Context) error {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err != nil {
+				log.WithField("error", grpc.ErrorDesc(err)).Debug("retry: call failed")
+			}
+			return err
+		}, retryOpts...)
+		if err != nil && err == ctx.Err() {
+			// The context was done before the first call
+			return status.FromContextError(err).Err()
+		}
+		return err
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/backoff"
+	"github.com/TheThingsNetwork/go-utils/grpc/restartstream"
+	. "github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func failingInvoker(calls *int, failures int, code codes.Code) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= failures {
+			return grpc.Errorf(code, "failure %d", *calls)
+		}
+		return nil
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultSettings
+	settings.Backoff = backoff.Config{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Factor: 1.6}
+	settings.Idempotent = []string{"/test.Test/Get", "/test.Test/Override"}
+	settings.Methods = map[string]restartstream.Settings{
+		"/test.Test/Override": {
+			RetryableCodes: []codes.Code{codes.InvalidArgument},
+			Backoff:        settings.Backoff,
+		},
+	}
+	interceptor := UnaryClientInterceptor(settings)
+
+	{
+		var calls int
+		err := interceptor(context.Background(), "/test.Test/Get", nil, nil, nil, failingInvoker(&calls, 2, codes.Unavailable))
+		a.So(err, ShouldBeNil)
+		a.So(calls, ShouldEqual, 3)
+	}
+
+	{
+		var calls int
+		err := interceptor(context.Background(), "/test.Test/Get", nil, nil, nil, failingInvoker(&calls, 10, codes.Unavailable))
+		a.So(grpc.Code(err), ShouldEqual, codes.Unavailable)
+		a.So(calls, ShouldEqual, settings.MaxAttempts)
+	}
+
+	{
+		var calls int
+		err := interceptor(context.Background(), "/test.Test/Get", nil, nil, nil, failingInvoker(&calls, 2, codes.InvalidArgument))
+		a.So(grpc.Code(err), ShouldEqual, codes.InvalidArgument)
+		a.So(calls, ShouldEqual, 1)
+	}
+
+	{
+		var calls int
+		err := interceptor(context.Background(), "/test.Test/Override", nil, nil, nil, failingInvoker(&calls, 2, codes.InvalidArgument))
+		a.So(err, ShouldBeNil)
+		a.So(calls, ShouldEqual, 3)
+	}
+
+	{
+		var calls int
+		err := interceptor(context.Background(), "/test.Test/Push", nil, nil, nil, failingInvoker(&calls, 2, codes.Unavailable))
+		a.So(grpc.Code(err), ShouldEqual, codes.Unavailable)
+		a.So(calls, ShouldEqual, 1)
+	}
+
+	{
+		var calls int
+		settings := settings
+		settings.MaxAttempts = 0
+		settings.Backoff.BaseDelay = 20 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		err := UnaryClientInterceptor(settings)(ctx, "/test.Test/Get", nil, nil, nil, failingInvoker(&calls, 100, codes.Unavailable))
+		a.So(grpc.Code(err), ShouldEqual, codes.Unavailable)
+		a.So(calls, ShouldBeLessThan, 3)
+	}
+
+	{
+		var calls int
+		settings := settings
+		settings.Backoff.BaseDelay = time.Second
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		err := UnaryClientInterceptor(settings)(ctx, "/test.Test/Get", nil, nil, nil, failingInvoker(&calls, 100, codes.Unavailable))
+		a.So(grpc.Code(err), ShouldEqual, codes.Unavailable)
+		a.So(calls, ShouldEqual, 1)
+	}
+
+	{
+		var calls int
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := interceptor(ctx, "/test.Test/Get", nil, nil, nil, failingInvoker(&calls, 100, codes.Unavailable))
+		a.So(grpc.Code(err), ShouldEqual, codes.Canceled)
+		a.So(calls, ShouldEqual, 0)
+	}
+}
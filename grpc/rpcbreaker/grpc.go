@@ -0,0 +1,21 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+// Package rpcbreaker implements gRPC interceptors that stop calling a failing server.
+package rpcbreaker
+
+import (
+	"github.com/TheThingsNetwork/go-utils/breaker"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// UnaryClientInterceptor passes calls through b. While b is open, calls fail immediately with a breaker.ErrOpen
+// error of type TemporarilyUnavailable.
+func UnaryClientInterceptor(b *breaker.Breaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		return b.Call(func() error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package rpcbreaker
+
+import (
+	"testing"
+
+	"github.com/TheThingsNetwork/go-utils/breaker"
+	"github.com/TheThingsNetwork/go-utils/errors"
+	. "github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	a := New(t)
+
+	config := breaker.DefaultConfig
+	config.MinRequests = 2
+	interceptor := UnaryClientInterceptor(breaker.New(config))
+
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return grpc.Errorf(codes.Unavailable, "server down")
+	}
+
+	for i := 0; i < 2; i++ {
+		err := interceptor(context.Background(), "test", nil, nil, nil, invoker)
+		a.So(grpc.Code(err), ShouldEqual, codes.Unavailable)
+	}
+	a.So(calls, ShouldEqual, 2)
+
+	err := interceptor(context.Background(), "test", nil, nil, nil, invoker)
+	a.So(calls, ShouldEqual, 2)
+	a.So(errors.GetType(err), ShouldEqual, errors.TemporarilyUnavailable)
+	a.So(errors.GRPCCode(err), ShouldEqual, codes.Unavailable)
+}
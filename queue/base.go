@@ -2,14 +2,51 @@
 // Use of this source code is governed by the MIT license that can be found in the LICENSE file.
 
 // Package queue implements different kinds of queues
+//
+// The Simple, JIT and Schedule queues are implemented for items of any type T (see SimpleOf, JITOf and ScheduleOf), so
+// that items come back typed. The queues of interface{} items are thin adapters around those implementations.
 package queue
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrDestroyed is returned by NextContext and Add when the queue is destroyed
+var ErrDestroyed = errors.New("queue: destroyed")
+
 // Base interface for Queue
 type Base interface {
 	// Next item in the Queue, this function blocks until the next item is available.
 	// It returns <nil> to all callers when Destroy() is called.
 	Next() interface{}
 
+	// NextContext is like Next, but it also returns when the context is done. It returns the context error if the
+	// context is done before an item is available, and ErrDestroyed when Destroy() is called.
+	NextContext(ctx context.Context) (interface{}, error)
+
+	// TryNext returns the next item in the Queue if it is available, without blocking.
+	TryNext() (interface{}, bool)
+
+	IsEmpty() bool
+
+	// Destroy the queue
+	Destroy()
+}
+
+// BaseOf is the type-safe variant of Base, for items of type T
+type BaseOf[T any] interface {
+	// Next item in the Queue, this function blocks until the next item is available.
+	// It returns the zero value to all callers when Destroy() is called.
+	Next() T
+
+	// NextContext is like Next, but it also returns when the context is done. It returns the context error if the
+	// context is done before an item is available, and ErrDestroyed when Destroy() is called.
+	NextContext(ctx context.Context) (T, error)
+
+	// TryNext returns the next item in the Queue if it is available, without blocking.
+	TryNext() (T, bool)
+
 	IsEmpty() bool
 
 	// Destroy the queue
@@ -0,0 +1,93 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestNextContext(t *testing.T) {
+	a := New(t)
+
+	queues := map[string]struct {
+		new func() Base
+		add func(q Base, i interface{}, at time.Time)
+	}{
+		"Simple": {
+			new: func() Base { return NewSimple() },
+			add: func(q Base, i interface{}, at time.Time) { q.(Simple).Add(i) },
+		},
+		"Priority": {
+			new: func() Base { return NewPriority(0) },
+			add: func(q Base, i interface{}, at time.Time) { q.(Priority).Add(i, 0) },
+		},
+		"JIT": {
+			new: func() Base { return NewJIT() },
+			add: func(q Base, i interface{}, at time.Time) { q.(JIT).Schedule(i, at) },
+		},
+		"Schedule": {
+			new: func() Base { return NewSchedule() },
+			add: func(q Base, i interface{}, at time.Time) { q.(Schedule).Schedule(i, at, time.Millisecond) },
+		},
+	}
+
+	for name, queue := range queues {
+		t.Logf("Testing %s", name)
+
+		q := queue.new()
+
+		i, ok := q.TryNext()
+		a.So(ok, ShouldBeFalse)
+		a.So(i, ShouldBeNil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), sleepTime)
+		i, err := q.NextContext(ctx)
+		cancel()
+		a.So(err, ShouldResemble, context.DeadlineExceeded)
+		a.So(i, ShouldBeNil)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(sleepTime)
+			cancel()
+		}()
+		i, err = q.NextContext(ctx)
+		a.So(err, ShouldEqual, context.Canceled)
+
+		queue.add(q, "item", time.Now())
+		time.Sleep(time.Millisecond)
+		i, ok = q.TryNext()
+		a.So(ok, ShouldBeTrue)
+		a.So(i, ShouldEqual, "item")
+
+		queue.add(q, "item", time.Now())
+		i, err = q.NextContext(context.Background())
+		a.So(err, ShouldBeNil)
+		a.So(i, ShouldEqual, "item")
+
+		go func() {
+			time.Sleep(sleepTime)
+			q.Destroy()
+		}()
+		i, err = q.NextContext(context.Background())
+		a.So(err, ShouldEqual, ErrDestroyed)
+		a.So(i, ShouldBeNil)
+	}
+
+	{
+		q := NewJIT()
+		q.Schedule("later", time.Now().Add(time.Hour))
+		_, ok := q.TryNext()
+		a.So(ok, ShouldBeFalse)
+		ctx, cancel := context.WithTimeout(context.Background(), sleepTime)
+		defer cancel()
+		_, err := q.NextContext(ctx)
+		a.So(err, ShouldResemble, context.DeadlineExceeded)
+		a.So(q.IsEmpty(), ShouldBeFalse)
+	}
+}
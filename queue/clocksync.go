@@ -0,0 +1,230 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrNotSynced is returned when a timestamp can not be converted because the ClockSync is not synchronized
+var ErrNotSynced = errors.New("queue: clock not synchronized")
+
+// ClockSyncSettings for a ClockSync
+type ClockSyncSettings struct {
+	// Samples is the number of recent synchronizations that are used to estimate the offset and drift of the counter
+	Samples int
+
+	// MaxError is the maximum difference between the time of a synchronization and the time that is estimated from its
+	// timestamp. If it is exceeded, for example because the gateway restarted, the previous synchronizations are
+	// discarded.
+	MaxError time.Duration
+}
+
+// DefaultClockSyncSettings for a ClockSync
+var DefaultClockSyncSettings = ClockSyncSettings{
+	Samples:  16,
+	MaxError: time.Second,
+}
+
+// ClockSync learns the offset and drift between the 32-bit microsecond counter of a gateway and the wall clock, so that
+// timestamps of the counter can be converted to time and back. The counter rolls over every 71 minutes, so timestamps
+// are converted to the time closest to the current time.
+type ClockSync struct {
+	settings ClockSyncSettings
+
+	mu      sync.Mutex
+	samples []clockSample
+	model   clockModel
+}
+
+// clockSample is a timestamp of the counter, extended beyond 32 bits, and the time at which it was observed
+type clockSample struct {
+	timestamp int64 // in microseconds
+	time      int64 // in Unix nanoseconds
+}
+
+// clockModel estimates time as y0 + slope * (timestamp - x0). The reference is the last sample, so that the floats
+// stay small.
+type clockModel struct {
+	x0    int64
+	y0    int64
+	dx    float64
+	dy    float64
+	slope float64 // nanoseconds per microsecond of the counter
+}
+
+func (m clockModel) time(timestamp int64) int64 {
+	return m.y0 + int64(math.Round(m.dy+m.slope*(float64(timestamp-m.x0)-m.dx)))
+}
+
+func (m clockModel) timestamp(time int64) int64 {
+	return m.x0 + int64(math.Round(m.dx+(float64(time-m.y0)-m.dy)/m.slope))
+}
+
+// NewClockSync returns a new ClockSync
+func NewClockSync(settings ClockSyncSettings) *ClockSync {
+	if settings.Samples < 1 {
+		settings.Samples = DefaultClockSyncSettings.Samples
+	}
+	if settings.MaxError == 0 {
+		settings.MaxError = DefaultClockSyncSettings.MaxError
+	}
+	return &ClockSync{settings: settings}
+}
+
+// extend returns the timestamp beyond 32 bits that is closest to around
+func extend(timestamp uint32, around int64) int64 {
+	return around + int64(int32(timestamp-uint32(around)))
+}
+
+// Sync synchronizes the clock with a timestamp of the counter and the time at which it was observed
+func (c *ClockSync) Sync(timestamp uint32, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sample := clockSample{timestamp: int64(timestamp), time: t.UnixNano()}
+	if len(c.samples) > 0 {
+		sample.timestamp = extend(timestamp, c.model.timestamp(sample.time))
+		if e := time.Duration(c.model.time(sample.timestamp) - sample.time); e > c.settings.MaxError || -e > c.settings.MaxError {
+			c.samples = nil
+			sample.timestamp = int64(timestamp)
+		}
+	}
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > c.settings.Samples {
+		c.samples = c.samples[len(c.samples)-c.settings.Samples:]
+	}
+	c.fit()
+}
+
+// fit estimates the offset and drift with a linear regression of the samples
+func (c *ClockSync) fit() {
+	last := c.samples[len(c.samples)-1]
+	m := clockModel{x0: last.timestamp, y0: last.time, slope: float64(time.Microsecond)}
+	for _, s := range c.samples {
+		m.dx += float64(s.timestamp - m.x0)
+		m.dy += float64(s.time - m.y0)
+	}
+	m.dx /= float64(len(c.samples))
+	m.dy /= float64(len(c.samples))
+	var sxy, sxx float64
+	for _, s := range c.samples {
+		x, y := float64(s.timestamp-m.x0)-m.dx, float64(s.time-m.y0)-m.dy
+		sxy += x * y
+		sxx += x * x
+	}
+	if sxx > 0 {
+		m.slope = sxy / sxx
+	}
+	c.model = m
+}
+
+// Synced returns true if the clock was synchronized
+func (c *ClockSync) Synced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.samples) > 0
+}
+
+// Drift returns the estimated drift of the counter in parts per million, positive if the counter is slow
+func (c *ClockSync) Drift() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return 0
+	}
+	return (c.model.slope/float64(time.Microsecond) - 1) * 1e6
+}
+
+// Time converts a timestamp of the counter to time
+func (c *ClockSync) Time(timestamp uint32) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return time.Time{}, ErrNotSynced
+	}
+	now := c.model.timestamp(time.Now().UnixNano())
+	return time.Unix(0, c.model.time(extend(timestamp, now))), nil
+}
+
+// Timestamp converts a time to a timestamp of the counter
+func (c *ClockSync) Timestamp(t time.Time) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return 0, ErrNotSynced
+	}
+	return uint32(c.model.timestamp(t.UnixNano())), nil
+}
+
+// clockSnapshot is the state of a ClockSync at one moment, so that many items can be compared without locking the
+// ClockSync and reading the current time for each of them
+type clockSnapshot struct {
+	synced bool
+	model  clockModel
+	now    int64 // the current timestamp of the counter
+}
+
+// snapshot returns the current state of the clock
+func (c *ClockSync) snapshot() *clockSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return &clockSnapshot{}
+	}
+	return &clockSnapshot{synced: true, model: c.model, now: c.model.timestamp(time.Now().UnixNano())}
+}
+
+// timeOf returns the time at which an item starts, which is converted from its timestamp if it has one and the clock
+// is synchronized
+func (c *clockSnapshot) timeOf(i ScheduleItem) time.Time {
+	if ts, ok := i.(ScheduleItemWithTimestamp); ok && c.synced {
+		return time.Unix(0, c.model.time(extend(uint32(ts.Timestamp()), c.now)))
+	}
+	return i.Time()
+}
+
+// before returns true if i ends before j starts. Timestamps are compared directly if both items have one, otherwise
+// they are converted to time.
+func (c *clockSnapshot) before(i, j ScheduleItem) bool {
+	if its, ok := i.(ScheduleItemWithTimestamp); ok {
+		if jts, ok := j.(ScheduleItemWithTimestamp); ok {
+			// The counter rolls over, so only the difference is meaningful
+			return int64(int32(uint32(jts.Timestamp())-uint32(its.Timestamp()))) > int64(i.Duration()/time.Microsecond)
+		}
+	}
+	return c.timeOf(i).Add(i.Duration()).Before(c.timeOf(j))
+}
+
+// SyncedSchedule is a Schedule for a gateway, in which the timestamps of items are timestamps of the 32-bit microsecond
+// counter of the gateway. The timestamps are converted to time with a ClockSync, so that items with and without a
+// timestamp can be checked for conflicts together.
+type SyncedSchedule interface {
+	Schedule
+
+	// ScheduleAtTimestamp schedules an item at the given timestamp of the counter, with the given duration
+	// this func returns the conflicts, and ErrNotSynced if the clock is not synchronized or the same errors as Add
+	ScheduleAtTimestamp(i interface{}, timestamp uint32, duration time.Duration) (Handle, []ScheduleItem, error)
+}
+
+// NewSyncedSchedule returns a new SyncedSchedule (see SyncedSchedule interface) that uses the given ClockSync
+func NewSyncedSchedule(clock *ClockSync, opts ...Option) SyncedSchedule {
+	q := newSchedule[interface{}](opts)
+	q.clock = clock
+	q.index.counter = true
+	q.jitQueue.shiftTimestamp = func(timestamp int64, by time.Duration) int64 {
+		return int64(uint32(timestamp + int64(by/time.Microsecond)))
+	}
+	return itemSchedule{q}
+}
+
+func (q itemSchedule) ScheduleAtTimestamp(i interface{}, timestamp uint32, duration time.Duration) (Handle, []ScheduleItem, error) {
+	t, err := q.clock.Time(timestamp)
+	if err != nil {
+		return q.handle(nil), nil, err
+	}
+	return q.ScheduleWithTimestamp(i, t, int64(timestamp), duration)
+}
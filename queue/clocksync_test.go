@@ -0,0 +1,90 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestClockSync(t *testing.T) {
+	a := New(t)
+
+	c := NewClockSync(DefaultClockSyncSettings)
+	a.So(c.Synced(), ShouldBeFalse)
+	_, err := c.Time(0)
+	a.So(err, ShouldEqual, ErrNotSynced)
+	_, err = c.Timestamp(time.Now())
+	a.So(err, ShouldEqual, ErrNotSynced)
+
+	// The counter runs 20ppm fast and rolls over while it is synchronized
+	now := time.Now()
+	start := now.Add(-10 * time.Minute)
+	first := uint32(math.MaxUint32 - 300*1000000)
+	counter := func(t time.Time) uint32 {
+		return first + uint32(float64(t.Sub(start)/time.Microsecond)*(1+20e-6))
+	}
+	for i := 0; i <= 60; i++ {
+		at := start.Add(time.Duration(i) * 10 * time.Second)
+		jitter := time.Duration(i%2*200-100) * time.Microsecond
+		c.Sync(counter(at), at.Add(jitter))
+	}
+	a.So(c.Synced(), ShouldBeTrue)
+	a.So(c.Drift(), ShouldAlmostEqual, -20, 1)
+
+	later := now.Add(time.Second)
+	converted, err := c.Time(counter(later))
+	a.So(err, ShouldBeNil)
+	a.So(converted, ShouldHappenWithin, time.Millisecond, later)
+	timestamp, err := c.Timestamp(later)
+	a.So(err, ShouldBeNil)
+	a.So(math.Abs(float64(int32(timestamp-counter(later)))), ShouldBeLessThan, 1000)
+
+	// The gateway restarted
+	c.Sync(1000000, now)
+	converted, err = c.Time(2000000)
+	a.So(err, ShouldBeNil)
+	a.So(converted, ShouldHappenWithin, time.Millisecond, later)
+	a.So(c.Drift(), ShouldEqual, 0)
+}
+
+func TestSyncedSchedule(t *testing.T) {
+	a := New(t)
+
+	_, _, err := NewSyncedSchedule(NewClockSync(DefaultClockSyncSettings)).ScheduleAtTimestamp("a", 0, time.Second)
+	a.So(err, ShouldEqual, ErrNotSynced)
+
+	c := NewClockSync(DefaultClockSyncSettings)
+	now := time.Now()
+	ts := uint32(math.MaxUint32 - 1000000) // rolls over in a second
+	at := func(d time.Duration) uint32 { return ts + uint32(d/time.Microsecond) }
+	c.Sync(ts, now)
+
+	q := NewSyncedSchedule(c)
+
+	h, conflicts, err := q.ScheduleAtTimestamp("a", at(2*time.Second), time.Second)
+	a.So(err, ShouldBeNil)
+	a.So(conflicts, ShouldBeEmpty)
+
+	// Items with and without a timestamp conflict
+	a.So(q.Conflicts(now.Add(2500*time.Millisecond), time.Second), ShouldHaveLength, 1)
+	a.So(conflictsOf(q.Schedule("b", now.Add(time.Second), 500*time.Millisecond)), ShouldBeEmpty)
+	a.So(conflictsOf(q.Schedule("c", now.Add(2900*time.Millisecond), 500*time.Millisecond)), ShouldHaveLength, 1)
+	a.So(q.ConflictsForTimestamp(int64(at(1200*time.Millisecond)), 200*time.Millisecond), ShouldHaveLength, 1)
+	a.So(q.ConflictsForTimestamp(int64(at(1600*time.Millisecond)), 200*time.Millisecond), ShouldBeEmpty)
+
+	_, t0, err := q.ScheduleASAP("d", time.Second)
+	a.So(err, ShouldBeNil)
+	a.So(t0, ShouldHappenWithin, time.Millisecond, now.Add(3400*time.Millisecond))
+
+	// The timestamp moves along with the time
+	a.So(h.Reschedule(now.Add(10*time.Second)), ShouldBeTrue)
+	a.So(q.ConflictsForTimestamp(int64(at(10*time.Second)), 100*time.Millisecond), ShouldHaveLength, 1)
+	a.So(q.ConflictsForTimestamp(int64(at(2*time.Second)), 100*time.Millisecond), ShouldBeEmpty)
+
+	q.Destroy()
+}
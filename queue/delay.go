@@ -0,0 +1,98 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Delay is a JIT Queue in which items have a key. Adding an item with the key of an item that is still in the Queue
+// replaces or coalesces with that item, similar to a debouncer.
+type Delay interface {
+	Base
+
+	// Add an item with the given key to the Delay Queue, will be returned by Next() at time
+	// If an item with the same key is in the Queue, it is replaced (or coalesced with the new item) and moved to time.
+	// Otherwise, if the Queue is full, the overflow policy is applied. It returns ErrFull if the item is rejected, and
+	// ErrDestroyed if the Queue is destroyed.
+	// The returned Handle can be used to cancel or reschedule the item, which is the same for all items with the key.
+	Add(key string, i interface{}, time time.Time) (Handle, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Queue was full
+	Dropped() uint64
+}
+
+// Coalesce combines the pending item in a Delay Queue with an item that is added with the same key
+type Coalesce func(pending, added interface{}) interface{}
+
+type delayItem struct {
+	jitItem
+	key string
+}
+
+type delay struct {
+	*jitQueue[interface{}]
+	coalesce Coalesce
+	pending  map[string]*jitEntry[interface{}]
+}
+
+// NewDelay returns a new Delay Queue (see Delay interface). If coalesce is nil, added items replace pending items.
+func NewDelay(coalesce Coalesce, opts ...Option) Delay {
+	q := &delay{
+		jitQueue: newJIT[interface{}](opts),
+		coalesce: coalesce,
+		pending:  make(map[string]*jitEntry[interface{}]),
+	}
+	q.jitQueue.onRemove = q.remove
+	return q
+}
+
+// remove forgets the key of an item that is returned, dropped or canceled, q.mu must be locked
+func (q *delay) remove(i JITItem) {
+	key := i.(*delayItem).key
+	if e, ok := q.pending[key]; ok && e.original == i {
+		delete(q.pending, key)
+	}
+}
+
+func (q *delay) Add(key string, i interface{}, t time.Time) (Handle, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil {
+		return q.handle(nil), ErrDestroyed
+	}
+	if e, ok := q.pending[key]; ok && e.index >= 0 {
+		if q.coalesce != nil {
+			i = q.coalesce(e.value, i)
+		}
+		first := q.queue[0]
+		item := &delayItem{jitItem: jitItem{time: t}, key: key}
+		e.item, e.original, e.value, e.time = item, item, i, t
+		heap.Fix(&q.queue, e.index)
+		if first == e || q.queue[0] == e {
+			q.notify()
+		}
+		return q.handle(e), nil
+	}
+	if err := q.makeRoom(q.overflow); err != nil {
+		if err == errDropped {
+			return q.handle(nil), nil
+		}
+		return q.handle(nil), err
+	}
+	if q.changed == nil {
+		return q.handle(nil), ErrDestroyed
+	}
+	h := q.add(&delayItem{jitItem: jitItem{time: t}, key: key}, i)
+	q.pending[key] = h.(*jitHandle[interface{}]).e
+	return h, nil
+}
+
+func (q *delay) Destroy() {
+	q.jitQueue.Destroy()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = make(map[string]*jitEntry[interface{}])
+}
@@ -0,0 +1,70 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestDelay(t *testing.T) {
+	a := New(t)
+
+	q := NewDelay(nil)
+	now := time.Now()
+
+	q.Add("a", "a1", now.Add(10*time.Millisecond))
+	q.Add("b", "b1", now.Add(20*time.Millisecond))
+	h, _ := q.Add("a", "a2", now.Add(30*time.Millisecond)) // replaces a1 and moves it after b1
+
+	a.So(q.Next(), ShouldEqual, "b1")
+	a.So(q.Next(), ShouldEqual, "a2")
+	a.So(time.Now(), ShouldHappenOnOrAfter, now.Add(30*time.Millisecond))
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	a.So(h.Cancel(), ShouldBeFalse)
+
+	// The key can be used again after the item was returned
+	q.Add("a", "a3", now)
+	a.So(q.Next(), ShouldEqual, "a3")
+
+	// The handle is the same for all items with the key
+	h, _ = q.Add("a", "a4", now.Add(time.Hour))
+	q.Add("a", "a5", now.Add(time.Hour))
+	a.So(h.Reschedule(now), ShouldBeTrue)
+	a.So(q.Next(), ShouldEqual, "a5")
+
+	h, _ = q.Add("a", "a6", now.Add(time.Hour))
+	a.So(h.Cancel(), ShouldBeTrue)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Add("a", "a7", now)
+	a.So(q.Next(), ShouldEqual, "a7")
+
+	q.Destroy()
+	a.So(q.Next(), ShouldBeNil)
+}
+
+func TestDelayCoalesce(t *testing.T) {
+	a := New(t)
+
+	q := NewDelay(func(pending, added interface{}) interface{} {
+		return append(pending.([]int), added.([]int)...)
+	}, WithCapacity(1, Reject))
+	now := time.Now()
+
+	_, err := q.Add("a", []int{1}, now.Add(time.Hour))
+	a.So(err, ShouldBeNil)
+	_, err = q.Add("a", []int{2}, now.Add(time.Hour))
+	a.So(err, ShouldBeNil)
+	_, err = q.Add("a", []int{3}, now)
+	a.So(err, ShouldBeNil)
+
+	// Coalescing does not need room in the Queue
+	_, err = q.Add("b", []int{4}, now)
+	a.So(err, ShouldEqual, ErrFull)
+	a.So(q.Dropped(), ShouldEqual, 1)
+
+	a.So(q.Next(), ShouldResemble, []int{1, 2, 3})
+}
@@ -0,0 +1,379 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/errors"
+	"github.com/TheThingsNetwork/go-utils/log"
+	"github.com/TheThingsNetwork/go-utils/rate"
+)
+
+// ErrDutyCycle is returned when an item would exceed the duty cycle of its band
+var ErrDutyCycle = &errors.ErrDescriptor{
+	MessageFormat: "queue: duty cycle of band {band} exhausted",
+	Type:          errors.ResourceExhausted,
+}
+
+// BandItem is a ScheduleItem in a band, such as a sub-band of a frequency plan. The airtime of the items in a band is
+// limited by the duty cycle of the band (see DutyCycleSchedule). Items that are not a BandItem are in the band "".
+type BandItem interface {
+	ScheduleItem
+	Band() string
+}
+
+func (s scheduleItem) Band() string {
+	return s.band
+}
+
+func bandOf(i JITItem) string {
+	if item, ok := unwrap(i).(BandItem); ok {
+		return item.Band()
+	}
+	return ""
+}
+
+// DutyCycleSettings for a DutyCycleSchedule
+type DutyCycleSettings struct {
+	// Window is the sliding window in which the airtime of each band is limited
+	Window time.Duration
+
+	// BucketSize is the bucket size of the counters. Items are delayed in steps of BucketSize until there is enough
+	// airtime.
+	BucketSize time.Duration
+
+	// Limits are the duty cycles of the bands: the fraction of the Window that the items in a band may use, for example
+	// 0.01 for 1%. The airtime of bands without a limit is not limited.
+	Limits map[string]float64
+
+	// Counter returns the Counter for the airtime (in nanoseconds) of the items in a band that were returned by the
+	// Schedule. Its retention must be at least the Window. If nil, an in-memory Counter is used. A Counter that is
+	// stored in Redis (see rate.NewRedisCounter) keeps the airtime across restarts and instances.
+	Counter func(band string) rate.Counter
+}
+
+// DefaultDutyCycleSettings for a DutyCycleSchedule
+var DefaultDutyCycleSettings = DutyCycleSettings{
+	Window:     time.Hour,
+	BucketSize: time.Minute,
+}
+
+// DutyCycleSchedule is a Schedule that limits the airtime of the items in each band to a duty cycle in a sliding
+// window, such as the regulatory duty cycle of 1% per sub-band per hour. The airtime of items that were returned by the
+// Schedule is tracked by a rate.Counter per band, the airtime of items that are still queued is taken from the Schedule.
+//
+// ScheduleASAP delays items until there is enough airtime. Items that would exceed the duty cycle are not added, and
+// an ErrDutyCycle error is returned, which is of type ResourceExhausted. Reschedule returns false if the item would
+// exceed the duty cycle at its new time.
+type DutyCycleSchedule interface {
+	Schedule
+
+	// ScheduleInBand schedules an item in a band at the given time, with the given duration
+	// this func returns the conflicts, or an ErrDutyCycle error if the item would exceed the duty cycle, in which case
+	// it is not added
+	ScheduleInBand(i interface{}, band string, time time.Time, duration time.Duration) (Handle, []ScheduleItem, error)
+
+	// ScheduleASAPInBand schedules an item in a band as soon as possible, given its duration and considering the
+	// existing Schedule and the duty cycle of the band.
+	// this func returns the time at which the item is scheduled, or an ErrDutyCycle error if the duration exceeds the
+	// duty cycle
+	ScheduleASAPInBand(i interface{}, band string, duration time.Duration) (Handle, time.Time, error)
+
+	// ScheduleWithinInBand schedules an item in a band in the first free slot within the window that has enough
+	// airtime (see ScheduleWithin). It returns an ErrDutyCycle error if there are free slots, but not enough airtime.
+	ScheduleWithinInBand(i interface{}, band string, earliest, latest time.Time, duration time.Duration) (Handle, time.Time, error)
+}
+
+// NewDutyCycleSchedule returns a new DutyCycleSchedule (see DutyCycleSchedule interface). It panics if the BucketSize
+// is not positive, for example because the Window is shorter than 60ns.
+func NewDutyCycleSchedule(settings DutyCycleSettings, opts ...Option) DutyCycleSchedule {
+	if settings.Window == 0 {
+		settings.Window = DefaultDutyCycleSettings.Window
+	}
+	if settings.BucketSize == 0 {
+		settings.BucketSize = settings.Window / 60
+	}
+	if settings.BucketSize <= 0 {
+		panic(fmt.Errorf("queue: invalid duty cycle bucket size %s", settings.BucketSize))
+	}
+	q := newSchedule[interface{}](opts)
+	q.dutyCycle = &dutyCycle{
+		DutyCycleSettings: settings,
+		counters:          make(map[string]rate.Counter),
+	}
+	q.jitQueue.onNext = func(next *jitEntry[interface{}]) {
+		q.setLast(next)
+		q.dutyCycle.record(next.item)
+	}
+	q.jitQueue.onReschedule = func(i JITItem, t time.Time) error {
+		item, ok := reschedule(i, t, q.shiftTimestamp).(ScheduleItem)
+		if !ok {
+			return nil
+		}
+		return q.checkDutyCycle(item, i)
+	}
+	return itemSchedule{q}
+}
+
+// dutyCycle keeps track of the airtime of items that were returned by a Schedule. Its methods must be called while
+// the Schedule is locked.
+type dutyCycle struct {
+	DutyCycleSettings
+	counters map[string]rate.Counter
+}
+
+// budget returns the airtime that a band may use in the Window, and false if the band is not limited
+func (d *dutyCycle) budget(band string) (time.Duration, bool) {
+	if d == nil {
+		return 0, false
+	}
+	limit, ok := d.Limits[band]
+	return time.Duration(limit * float64(d.Window)), ok
+}
+
+// exceeds returns true if an item in the band with the given duration can never be scheduled
+func (d *dutyCycle) exceeds(band string, duration time.Duration) bool {
+	budget, ok := d.budget(band)
+	return ok && duration > budget
+}
+
+func (d *dutyCycle) exhausted(band string) error {
+	return ErrDutyCycle.New(errors.Attributes{"band": band})
+}
+
+func (d *dutyCycle) counter(band string) rate.Counter {
+	counter, ok := d.counters[band]
+	if !ok {
+		if d.Counter != nil {
+			counter = d.Counter(band)
+		} else {
+			counter = rate.NewCounter(d.BucketSize, d.Window)
+		}
+		d.counters[band] = counter
+	}
+	return counter
+}
+
+// record adds the airtime of an item that is returned by the Schedule to the counter of its band
+func (d *dutyCycle) record(next JITItem) {
+	item, ok := next.(ScheduleItem)
+	if !ok {
+		return
+	}
+	band := bandOf(item)
+	if _, ok := d.budget(band); !ok {
+		return
+	}
+	if err := d.counter(band).Add(item.Time(), uint64(item.Duration())); err != nil {
+		log.Get().WithError(err).WithField("band", band).Warn("queue: could not record airtime")
+	}
+}
+
+// overlap returns the part of the duration of an item that is in the window between start and end
+func overlap(i ScheduleItem, start, end time.Time) time.Duration {
+	from, to := i.Time(), i.Time().Add(i.Duration())
+	if from.Before(start) {
+		from = start
+	}
+	if to.After(end) {
+		to = end
+	}
+	if !to.After(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// queuedIn returns the queued items in the band, except the item that was added as except, q.mu must be locked
+func (q *schedule[T]) queuedIn(band string, except JITItem) (queued []ScheduleItem) {
+	for _, e := range q.queue {
+		if e.original == except {
+			continue
+		}
+		if item, ok := e.item.(ScheduleItem); ok && bandOf(item) == band {
+			queued = append(queued, item)
+		}
+	}
+	return
+}
+
+// queuedAirtime keeps running sums of the starts and ends of queued items, so that their airtime in any window is
+// calculated without visiting all items. Times are in nanoseconds since the start of the first item.
+type queuedAirtime struct {
+	origin       time.Time
+	starts, ends []int64 // sorted
+	startSums    []int64 // startSums[i] is the sum of starts[:i]
+	endSums      []int64 // endSums[i] is the sum of ends[:i]
+}
+
+func newQueuedAirtime(queued []ScheduleItem) *queuedAirtime {
+	a := &queuedAirtime{
+		starts:    make([]int64, len(queued)),
+		ends:      make([]int64, len(queued)),
+		startSums: make([]int64, len(queued)+1),
+		endSums:   make([]int64, len(queued)+1),
+	}
+	for _, item := range queued {
+		if a.origin.IsZero() || item.Time().Before(a.origin) {
+			a.origin = item.Time()
+		}
+	}
+	for i, item := range queued {
+		a.starts[i] = int64(item.Time().Sub(a.origin))
+		a.ends[i] = a.starts[i] + int64(item.Duration())
+	}
+	sort.Slice(a.starts, func(i, j int) bool { return a.starts[i] < a.starts[j] })
+	sort.Slice(a.ends, func(i, j int) bool { return a.ends[i] < a.ends[j] })
+	for i := range queued {
+		a.startSums[i+1] = a.startSums[i] + a.starts[i]
+		a.endSums[i+1] = a.endSums[i] + a.ends[i]
+	}
+	return a
+}
+
+// before returns the airtime of the items before t
+func (a *queuedAirtime) before(t time.Time) time.Duration {
+	x := int64(t.Sub(a.origin))
+	// Every item that started adds the time since its start, until it ends
+	started := sort.Search(len(a.starts), func(i int) bool { return a.starts[i] >= x })
+	ended := sort.Search(len(a.ends), func(i int) bool { return a.ends[i] >= x })
+	return time.Duration((int64(started)*x - a.startSums[started]) - (int64(ended)*x - a.endSums[ended]))
+}
+
+// in returns the airtime of the items in the window between start and end
+func (a *queuedAirtime) in(start, end time.Time) time.Duration {
+	return a.before(end) - a.before(start)
+}
+
+// last returns the end of the last item, or the zero time if there are no items
+func (a *queuedAirtime) last() time.Time {
+	if len(a.ends) == 0 {
+		return time.Time{}
+	}
+	return a.origin.Add(time.Duration(a.ends[len(a.ends)-1]))
+}
+
+// endsBetween returns the ends of the items that end after start and before end
+func (a *queuedAirtime) endsBetween(start, end time.Time) []time.Time {
+	from, to := int64(start.Sub(a.origin)), int64(end.Sub(a.origin))
+	i := sort.Search(len(a.ends), func(i int) bool { return a.ends[i] > from })
+	var ends []time.Time
+	for ; i < len(a.ends) && a.ends[i] < to; i++ {
+		ends = append(ends, a.origin.Add(time.Duration(a.ends[i])))
+	}
+	return ends
+}
+
+// airtime returns the airtime of the band in the window that ends at end, q.mu must be locked
+func (q *schedule[T]) airtime(band string, queued *queuedAirtime, now, end time.Time) (airtime time.Duration, err error) {
+	start := end.Add(-1 * q.dutyCycle.Window)
+	if now.After(start) {
+		events, err := q.dutyCycle.counter(band).Get(now, now.Sub(start))
+		if err != nil {
+			return 0, err
+		}
+		airtime = time.Duration(events)
+	}
+	return airtime + queued.in(start, end), nil
+}
+
+// exceedsDutyCycle returns true if an item in the band would exceed the duty cycle in any window that contains it. The
+// queued item except is not counted, so that an item can be moved. q.mu must be locked
+func (q *schedule[T]) exceedsDutyCycle(band string, t time.Time, duration time.Duration, except JITItem) (bool, error) {
+	budget, ok := q.dutyCycle.budget(band)
+	if !ok {
+		return false, nil
+	}
+	if duration > budget {
+		return true, nil
+	}
+	return q.exceedsBudget(band, budget, newQueuedAirtime(q.queuedIn(band, except)), time.Now(), t, duration)
+}
+
+// exceedsBudget returns true if an item in the band would exceed the budget in any window that contains it, given the
+// queued airtime. q.mu must be locked
+func (q *schedule[T]) exceedsBudget(band string, budget time.Duration, queued *queuedAirtime, now, t time.Time, duration time.Duration) (bool, error) {
+	item := newItem(nil, band, t, duration)
+
+	// The window that ends with the item, and the windows that end with later items that would contain the item
+	end := t.Add(duration)
+	ends := append([]time.Time{end}, queued.endsBetween(end, end.Add(q.dutyCycle.Window))...)
+	for _, end := range ends {
+		airtime, err := q.airtime(band, queued, now, end)
+		if err != nil {
+			return true, err
+		}
+		if airtime+overlap(item, end.Add(-1*q.dutyCycle.Window), end) > budget {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkDutyCycle returns an ErrDutyCycle error if the item would exceed the duty cycle of its band, not counting the
+// queued item except. q.mu must be locked
+func (q *schedule[T]) checkDutyCycle(i ScheduleItem, except JITItem) error {
+	band := bandOf(i)
+	exceeded, err := q.exceedsDutyCycle(band, i.Time(), i.Duration(), except)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return q.dutyCycle.exhausted(band)
+	}
+	return nil
+}
+
+// available returns a func that returns the first time at or after at at which an item in the band has enough airtime,
+// or nil if the band is not limited. q.mu must be locked
+func (q *schedule[T]) available(band string) func(at time.Time, duration time.Duration) (time.Time, error) {
+	budget, ok := q.dutyCycle.budget(band)
+	if !ok {
+		return nil
+	}
+	return func(at time.Time, duration time.Duration) (time.Time, error) {
+		if duration > budget {
+			return time.Time{}, q.dutyCycle.exhausted(band)
+		}
+		now := time.Now()
+		queued := newQueuedAirtime(q.queuedIn(band, nil))
+		// All airtime has left the window at the horizon
+		horizon := at
+		if now.After(horizon) {
+			horizon = now
+		}
+		if last := queued.last(); last.After(horizon) {
+			horizon = last
+		}
+		horizon = horizon.Add(q.dutyCycle.Window)
+		for t := at; t.Before(horizon); t = t.Add(q.dutyCycle.BucketSize) {
+			exceeded, err := q.exceedsBudget(band, budget, queued, now, t, duration)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if !exceeded {
+				return t, nil
+			}
+		}
+		return horizon, nil
+	}
+}
+
+func (q itemSchedule) ScheduleInBand(i interface{}, band string, time time.Time, duration time.Duration) (Handle, []ScheduleItem, error) {
+	h, conflicts, err := q.add(newItem(nil, band, time, duration), i)
+	return h, items(conflicts), err
+}
+
+func (q itemSchedule) ScheduleASAPInBand(i interface{}, band string, duration time.Duration) (Handle, time.Time, error) {
+	h, _, t, err := q.scheduleASAP(i, [][]string{nil}, band, duration)
+	return h, t, err
+}
+
+func (q itemSchedule) ScheduleWithinInBand(i interface{}, band string, earliest, latest time.Time, duration time.Duration) (Handle, time.Time, error) {
+	h, _, t, conflicts, err := q.scheduleWithin(i, [][]string{nil}, band, earliest, latest, duration)
+	return h, t, conflictError(conflicts, err)
+}
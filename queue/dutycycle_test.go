@@ -0,0 +1,165 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/errors"
+	"github.com/TheThingsNetwork/go-utils/rate"
+	. "github.com/smartystreets/assertions"
+)
+
+func typeOf(err error) errors.Type {
+	if err, ok := err.(errors.Error); ok {
+		return err.Type()
+	}
+	return errors.Unknown
+}
+
+func TestDutyCycleSchedule(t *testing.T) {
+	a := New(t)
+
+	counter := rate.NewCounter(time.Minute, time.Hour)
+	settings := DefaultDutyCycleSettings
+	settings.Limits = map[string]float64{"eu": 0.01} // 36s per hour
+	settings.Counter = func(band string) rate.Counter { return counter }
+
+	q := NewDutyCycleSchedule(settings)
+	now := time.Now()
+	base := now.Add(-time.Minute)
+
+	_, conflicts, err := q.ScheduleInBand("a", "eu", base, 20*time.Second)
+	a.So(err, ShouldBeNil)
+	a.So(conflicts, ShouldBeEmpty)
+
+	// The duty cycle would be exceeded
+	_, _, err = q.ScheduleInBand("b", "eu", now.Add(10*time.Minute), 20*time.Second)
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+	_, _, err = q.Add(&scheduleItem{jitItem: jitItem{time: now.Add(10 * time.Minute)}, duration: 20 * time.Second, band: "eu"})
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+	_, _, err = q.ScheduleWithinInBand("b", "eu", base, base.Add(30*time.Minute), 20*time.Second)
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+	_, _, err = q.ScheduleASAPInBand("b", "eu", 40*time.Second)
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+
+	// Conflicts are still reported as such
+	_, _, err = q.ScheduleWithinInBand("b", "eu", base, base.Add(20*time.Second), 10*time.Second)
+	a.So(err, ShouldHaveSameTypeAs, &ConflictError{})
+
+	// A shorter item fits in the remaining airtime
+	_, t0, err := q.ScheduleWithinInBand("b", "eu", base, base.Add(30*time.Minute), 10*time.Second)
+	a.So(err, ShouldBeNil)
+	a.So(t0, ShouldHappenWithin, time.Microsecond, base.Add(20*time.Second))
+
+	// Other bands are not limited
+	_, _, err = q.ScheduleInBand("c", "us", now.Add(10*time.Minute), time.Minute)
+	a.So(err, ShouldBeNil)
+
+	// The item is delayed until the first items have left the window
+	_, t1, err := q.ScheduleASAPInBand("d", "eu", 20*time.Second)
+	a.So(err, ShouldBeNil)
+	a.So(t1, ShouldHappenAfter, base.Add(time.Hour-6*time.Second))
+	a.So(t1, ShouldHappenBefore, now.Add(time.Hour+settings.BucketSize))
+
+	// Items that were returned are counted
+	a.So(q.Next(), ShouldEqual, "a")
+	a.So(q.Next(), ShouldEqual, "b")
+	airtime, _ := counter.Get(time.Now(), time.Hour)
+	a.So(time.Duration(airtime), ShouldEqual, 30*time.Second)
+	_, _, err = q.ScheduleInBand("e", "eu", now.Add(10*time.Minute), 10*time.Second)
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+
+	// The counter can be shared
+	q2 := NewDutyCycleSchedule(settings)
+	_, _, err = q2.ScheduleInBand("e", "eu", now.Add(10*time.Minute), 10*time.Second)
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+	_, _, err = q2.ScheduleInBand("e", "eu", now.Add(10*time.Minute), 5*time.Second)
+	a.So(err, ShouldBeNil)
+
+	q.Destroy()
+	q2.Destroy()
+}
+
+func TestDutyCycleScheduleLaterItems(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultDutyCycleSettings
+	settings.Limits = map[string]float64{"eu": 0.01}
+
+	q := NewDutyCycleSchedule(settings)
+	now := time.Now()
+
+	q.ScheduleInBand("a", "eu", now.Add(30*time.Minute), 30*time.Second)
+
+	// The window of the later item would be exceeded
+	_, _, err := q.ScheduleInBand("b", "eu", now.Add(time.Minute), 10*time.Second)
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+
+	// Add and Schedule are not limited
+	_, conflicts, err := q.Schedule("c", now.Add(time.Minute), 10*time.Second)
+	a.So(err, ShouldBeNil)
+	a.So(conflicts, ShouldBeEmpty)
+	a.So(q.Dropped(), ShouldEqual, 0)
+
+	q.Destroy()
+}
+
+func TestDutyCycleScheduleAddReschedule(t *testing.T) {
+	a := New(t)
+
+	settings := DefaultDutyCycleSettings
+	settings.Limits = map[string]float64{"eu": 0.01} // 36s per hour
+
+	q := NewDutyCycleSchedule(settings)
+	now := time.Now()
+
+	x, _, _ := q.Add(&scheduleItem{jitItem: jitItem{time: now.Add(time.Minute)}, duration: 20 * time.Second, band: "eu"})
+	y, _, _ := q.Add(&scheduleItem{jitItem: jitItem{time: now.Add(2 * time.Hour)}, duration: 20 * time.Second, band: "eu"})
+
+	// Add does not add items that would exceed the duty cycle
+	z, _, err := q.Add(&scheduleItem{jitItem: jitItem{time: now.Add(10 * time.Minute)}, duration: 20 * time.Second, band: "eu"})
+	a.So(typeOf(err), ShouldEqual, errors.ResourceExhausted)
+	a.So(z.Cancel(), ShouldBeFalse)
+	a.So(q.Conflicts(now.Add(10*time.Minute), 20*time.Second), ShouldBeEmpty)
+
+	// Items are not rescheduled into a window in which they would exceed the duty cycle
+	a.So(y.Reschedule(now.Add(10*time.Minute)), ShouldBeFalse)
+	a.So(y.Reschedule(now.Add(3*time.Hour)), ShouldBeTrue)
+
+	// The airtime of the item itself is not counted when it is rescheduled
+	a.So(x.Reschedule(now.Add(2*time.Minute)), ShouldBeTrue)
+}
+
+func TestDutyCycleSettings(t *testing.T) {
+	a := New(t)
+
+	a.So(func() { NewDutyCycleSchedule(DutyCycleSettings{Window: 30}) }, ShouldPanic)
+	a.So(func() { NewDutyCycleSchedule(DutyCycleSettings{Window: time.Hour, BucketSize: -time.Minute}) }, ShouldPanic)
+	a.So(func() { NewDutyCycleSchedule(DutyCycleSettings{Window: time.Hour}).Destroy() }, ShouldNotPanic)
+}
+
+func TestQueuedAirtime(t *testing.T) {
+	a := New(t)
+
+	now := time.Now()
+	r := rand.New(rand.NewSource(42))
+	queued := make([]ScheduleItem, 100)
+	for i := range queued {
+		queued[i] = newItem(nil, "", now.Add(time.Duration(r.Int63n(int64(time.Hour)))), time.Duration(r.Int63n(int64(time.Minute))))
+	}
+	airtime := newQueuedAirtime(queued)
+	for i := 0; i < 100; i++ {
+		start := now.Add(time.Duration(r.Int63n(int64(2*time.Hour))) - 30*time.Minute)
+		end := start.Add(time.Duration(r.Int63n(int64(time.Hour))))
+		var expected time.Duration
+		for _, item := range queued {
+			expected += overlap(item, start, end)
+		}
+		a.So(airtime.in(start, end), ShouldEqual, expected)
+	}
+	a.So(newQueuedAirtime(nil).in(now, now.Add(time.Hour)), ShouldEqual, 0)
+}
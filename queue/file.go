@@ -0,0 +1,292 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/log"
+)
+
+// Codec serializes the items of a queue that is persisted in a file
+type Codec interface {
+	Marshal(i interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// JSONCodec serializes items as JSON. If New is set, items are decoded into the value that it returns, otherwise
+// items are decoded into an interface{}.
+type JSONCodec struct {
+	New func() interface{}
+}
+
+// Marshal implements Codec
+func (c JSONCodec) Marshal(i interface{}) ([]byte, error) {
+	return json.Marshal(i)
+}
+
+// Unmarshal implements Codec
+func (c JSONCodec) Unmarshal(data []byte) (interface{}, error) {
+	if c.New == nil {
+		var i interface{}
+		err := json.Unmarshal(data, &i)
+		return i, err
+	}
+	i := c.New()
+	err := json.Unmarshal(data, i)
+	return i, err
+}
+
+// FileSettings for queues that are persisted in a file
+type FileSettings struct {
+	// Codec serializes the items
+	Codec Codec
+
+	// Sync the file after every change. Without Sync, items are not lost when the process crashes, but they may be
+	// lost when the machine crashes.
+	Sync bool
+
+	// CompactThreshold is the number of records in the file after which the file is compacted if less than half of
+	// them are still needed. A negative threshold disables compaction.
+	CompactThreshold int
+}
+
+// DefaultFileSettings for queues that are persisted in a file
+var DefaultFileSettings = FileSettings{
+	Codec:            JSONCodec{},
+	Sync:             true,
+	CompactThreshold: 1024,
+}
+
+func (s FileSettings) codec() Codec {
+	if s.Codec == nil {
+		return DefaultFileSettings.Codec
+	}
+	return s.Codec
+}
+
+func logRemoveError(id uint64, err error) {
+	log.Get().WithError(err).WithField("id", id).Warn("queue: could not persist removal of item")
+}
+
+type fileItem struct {
+	id   uint64
+	item interface{}
+	data []byte
+}
+
+type fileSimple struct {
+	*simpleQueue[interface{}]
+	log   *fileLog
+	codec Codec
+}
+
+// OpenSimple opens a Simple Queue that is persisted in the file at path. Items that were added but not yet returned
+// are replayed when the Queue is opened again, also after a crash. Items are removed from the file when they are
+// returned by Next(). Destroy closes the file, but keeps the items in it.
+func OpenSimple(path string, settings FileSettings, opts ...Option) (Simple, error) {
+	fileLog, err := openLog(path, settings.Sync, settings.CompactThreshold)
+	if err != nil {
+		return nil, err
+	}
+	q := &fileSimple{
+		simpleQueue: newSimple[interface{}](opts),
+		log:         fileLog,
+		codec:       settings.codec(),
+	}
+	q.onRemove = func(i interface{}) {
+		f := i.(*fileItem)
+		if err := q.log.remove(f.id); err != nil {
+			logRemoveError(f.id, err)
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, r := range fileLog.pending() {
+		i, err := q.codec.Unmarshal(r.data)
+		if err != nil {
+			fileLog.close()
+			return nil, fmt.Errorf("queue: could not unmarshal item %d: %s", r.id, err)
+		}
+		f := &fileItem{id: r.id, item: i, data: r.data}
+		if !replayRoom(&q.bounds, &q.mu, q.len, func() { q.next() }) {
+			q.onRemove(f)
+			continue
+		}
+		q.queue = append(q.queue, simpleEntry[interface{}]{item: f, added: time.Now()})
+	}
+	q.onAdd = func(i interface{}) error {
+		// The ID is assigned while locked, so that items are replayed in the order in which they were added
+		f := i.(*fileItem)
+		f.id = q.log.lastID + 1
+		return q.log.add(f.id, time.Time{}, 0, f.data)
+	}
+	return q, nil
+}
+
+// replayRoom makes room for an item that is replayed, according to the overflow policy. It returns false if the item
+// is dropped or rejected. With the Block policy, all items are replayed, and producers block until the Queue has room.
+func replayRoom(b *bounds, mu *sync.Mutex, size func() int, dropOldest func()) bool {
+	if b.overflow == Block {
+		return true
+	}
+	return b.makeRoom(mu, b.overflow, size, dropOldest) == nil
+}
+
+func (q *fileSimple) Add(i interface{}) error {
+	data, err := q.codec.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return q.simpleQueue.Add(&fileItem{item: i, data: data})
+}
+
+func (q *fileSimple) Next() interface{} {
+	i, _ := q.NextContext(context.Background())
+	return i
+}
+
+func (q *fileSimple) NextContext(ctx context.Context) (interface{}, error) {
+	i, err := q.simpleQueue.NextContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return i.(*fileItem).item, nil
+}
+
+func (q *fileSimple) NextBatch(max int, maxWait time.Duration) []interface{} {
+	batch, _ := q.NextBatchContext(context.Background(), max, maxWait)
+	return batch
+}
+
+func (q *fileSimple) NextBatchContext(ctx context.Context, max int, maxWait time.Duration) ([]interface{}, error) {
+	batch, err := q.simpleQueue.NextBatchContext(ctx, max, maxWait)
+	for i := range batch {
+		batch[i] = batch[i].(*fileItem).item
+	}
+	return batch, err
+}
+
+func (q *fileSimple) TryNext() (interface{}, bool) {
+	i, ok := q.simpleQueue.TryNext()
+	if !ok {
+		return nil, false
+	}
+	return i.(*fileItem).item, true
+}
+
+func (q *fileSimple) Destroy() {
+	q.simpleQueue.Destroy()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.log.close()
+}
+
+type fileJITItem struct {
+	JITItem
+	id      uint64
+	wrapped bool // the item was added with Schedule(i, time)
+	data    []byte
+}
+
+type fileJIT struct {
+	*jitQueue[interface{}]
+	log   *fileLog
+	codec Codec
+}
+
+// OpenJIT opens a JIT Queue that is persisted in the file at path. Items that were added but not yet returned are
+// replayed when the Queue is opened again, also after a crash, and rescheduled items keep their new time. Items are
+// removed from the file when they are returned by Next(), dropped or canceled. Items that are added with Add must be
+// decoded by the Codec into a JITItem. Destroy closes the file, but keeps the items in it.
+func OpenJIT(path string, settings FileSettings, opts ...Option) (JIT, error) {
+	fileLog, err := openLog(path, settings.Sync, settings.CompactThreshold)
+	if err != nil {
+		return nil, err
+	}
+	q := &fileJIT{
+		jitQueue: newJIT[interface{}](opts),
+		log:      fileLog,
+		codec:    settings.codec(),
+	}
+	q.onRemove = func(i JITItem) {
+		f := i.(*fileJITItem)
+		if err := q.log.remove(f.id); err != nil {
+			logRemoveError(f.id, err)
+		}
+	}
+	q.mu.Lock()
+	for _, r := range fileLog.pending() {
+		i, err := q.codec.Unmarshal(r.data)
+		if err != nil {
+			q.mu.Unlock()
+			fileLog.close()
+			return nil, fmt.Errorf("queue: could not unmarshal item %d: %s", r.id, err)
+		}
+		t := time.Unix(0, r.time)
+		f := &fileJITItem{id: r.id, wrapped: r.flags&flagWrapped != 0, data: r.data}
+		if f.wrapped {
+			f.JITItem = &jitItem{time: t}
+		} else if jit, ok := i.(JITItem); ok {
+			f.JITItem = jit
+		} else {
+			q.mu.Unlock()
+			fileLog.close()
+			return nil, fmt.Errorf("queue: item %d is a %T, not a JITItem", r.id, i)
+		}
+		if !replayRoom(&q.bounds, &q.mu, q.len, q.dropFirst) {
+			q.onRemove(f)
+			continue
+		}
+		e := q.jitQueue.add(f, i).(*jitHandle[interface{}]).e
+		if !f.Time().Equal(t) {
+			// Like Reschedule, but q.mu is already locked
+			e.item, e.time = reschedule(f, t, q.shiftTimestamp), t
+			heap.Fix(&q.queue, e.index)
+		}
+	}
+	q.mu.Unlock()
+	q.onAdd = func(i JITItem) error {
+		f := i.(*fileJITItem)
+		f.id = q.log.lastID + 1
+		var flags byte
+		if f.wrapped {
+			flags |= flagWrapped
+		}
+		return q.log.add(f.id, f.Time(), flags, f.data)
+	}
+	q.onReschedule = func(i JITItem, t time.Time) error {
+		return q.log.reschedule(i.(*fileJITItem).id, t)
+	}
+	return q, nil
+}
+
+func (q *fileJIT) Add(i JITItem) (Handle, error) {
+	return q.add(i, i, false)
+}
+
+func (q *fileJIT) Schedule(i interface{}, time time.Time) (Handle, error) {
+	return q.add(&jitItem{time: time}, i, true)
+}
+
+// add marshals v and adds the item i, which is returned as v, to the queue
+func (q *fileJIT) add(i JITItem, v interface{}, wrapped bool) (Handle, error) {
+	data, err := q.codec.Marshal(v)
+	if err != nil {
+		return q.handle(nil), err
+	}
+	return q.push(&fileJITItem{JITItem: i, wrapped: wrapped, data: data}, v)
+}
+
+func (q *fileJIT) Destroy() {
+	q.jitQueue.Destroy()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.log.close()
+}
@@ -0,0 +1,287 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "queue.log"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileSimple(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	q, err := OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Add("a"), ShouldBeNil)
+	a.So(q.Add("b"), ShouldBeNil)
+	a.So(q.Add("c"), ShouldBeNil)
+	a.So(q.Next(), ShouldEqual, "a")
+	q.Destroy()
+
+	q, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Next(), ShouldEqual, "b")
+	a.So(q.Add("d"), ShouldBeNil)
+	q.Destroy()
+
+	// A partially written record is discarded
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	a.So(err, ShouldBeNil)
+	record := (&logRecord{op: opAdd, id: 100, data: []byte(`"e"`)}).marshal()
+	f.Write(record[:len(record)-1])
+	f.Close()
+
+	q, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Add("f"), ShouldBeNil)
+	a.So(q.Next(), ShouldEqual, "c")
+	a.So(q.Next(), ShouldEqual, "d")
+	a.So(q.Next(), ShouldEqual, "f")
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Destroy()
+
+	q, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Destroy()
+}
+
+func TestFileCompaction(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	settings := DefaultFileSettings
+	settings.Sync = false
+	settings.CompactThreshold = 10
+
+	q, err := OpenSimple(path, settings)
+	a.So(err, ShouldBeNil)
+	q.Add("keep")
+	for i := 0; i < 100; i++ {
+		q.Add(i)
+		q.TryNext()
+		q.TryNext()
+		q.Add("keep")
+	}
+	a.So(q.(*fileSimple).log.records, ShouldBeLessThan, settings.CompactThreshold)
+	q.Destroy()
+
+	q, err = OpenSimple(path, settings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Next(), ShouldEqual, "keep")
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Destroy()
+}
+
+type testJITItem struct {
+	Name string
+	At   time.Time
+}
+
+func (i *testJITItem) Time() time.Time { return i.At }
+
+func TestFileJIT(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	settings := DefaultFileSettings
+	settings.Codec = JSONCodec{New: func() interface{} { return new(testJITItem) }}
+
+	now := time.Now()
+
+	q, err := OpenJIT(path, settings)
+	a.So(err, ShouldBeNil)
+	q.Add(&testJITItem{Name: "first", At: now.Add(-3 * time.Millisecond)})
+	canceled, _ := q.Add(&testJITItem{Name: "canceled", At: now.Add(-2 * time.Millisecond)})
+	rescheduled, _ := q.Add(&testJITItem{Name: "rescheduled", At: now.Add(-1 * time.Millisecond)})
+	q.Add(&testJITItem{Name: "second", At: now.Add(-2 * time.Millisecond)})
+	a.So(canceled.Cancel(), ShouldBeTrue)
+	a.So(rescheduled.Reschedule(now.Add(-4*time.Millisecond)), ShouldBeTrue)
+	a.So(q.Next().(*testJITItem).Name, ShouldEqual, "rescheduled")
+	q.Destroy()
+
+	q, err = OpenJIT(path, settings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Next().(*testJITItem).Name, ShouldEqual, "first")
+	late, _ := q.Add(&testJITItem{Name: "late", At: now.Add(time.Hour)})
+	late.Reschedule(now.Add(-time.Millisecond))
+	q.Destroy()
+
+	q, err = OpenJIT(path, settings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Next().(*testJITItem).Name, ShouldEqual, "second")
+	a.So(q.Next().(*testJITItem).Name, ShouldEqual, "late")
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Destroy()
+
+	// Items added with Schedule are decoded with the Codec and keep their time
+	os.Remove(path)
+	q, err = OpenJIT(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	q.Schedule("later", now.Add(time.Hour))
+	q.Schedule("now", now)
+	q.Destroy()
+
+	q, err = OpenJIT(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Next(), ShouldEqual, "now")
+	i, ok := q.TryNext()
+	a.So(ok, ShouldBeFalse)
+	a.So(i, ShouldBeNil)
+	q.Destroy()
+}
+
+func TestFileDestroyed(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	q, err := OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	q.Destroy()
+	a.So(q.Add("lost"), ShouldEqual, ErrDestroyed)
+
+	j, err := OpenJIT(path+".jit", DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	j.Destroy()
+	_, err = j.Schedule("lost", time.Now())
+	a.So(err, ShouldEqual, ErrDestroyed)
+}
+
+func TestFileReplayOverflow(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	q, err := OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	for i := 0; i < 4; i++ {
+		q.Add(i)
+	}
+	q.Destroy()
+
+	// The oldest items are dropped, also from the file
+	q, err = OpenSimple(path, DefaultFileSettings, WithCapacity(2, DropOldest))
+	a.So(err, ShouldBeNil)
+	a.So(q.Dropped(), ShouldEqual, 2)
+	q.Destroy()
+
+	// The newest items are rejected
+	q, err = OpenSimple(path, DefaultFileSettings, WithCapacity(1, Reject))
+	a.So(err, ShouldBeNil)
+	a.So(q.Dropped(), ShouldEqual, 1)
+	a.So(q.Next(), ShouldEqual, 2)
+	q.Destroy()
+
+	q, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Destroy()
+
+	// JIT Queues drop the first item in the Queue, like when the items were added
+	path += ".jit"
+	now := time.Now()
+	j, err := OpenJIT(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	j.Schedule("later", now.Add(-time.Millisecond))
+	j.Schedule("earliest", now.Add(-2*time.Millisecond))
+	j.Destroy()
+
+	j, err = OpenJIT(path, DefaultFileSettings, WithCapacity(1, DropOldest))
+	a.So(err, ShouldBeNil)
+	a.So(j.Dropped(), ShouldEqual, 1)
+	a.So(j.Next(), ShouldEqual, "earliest")
+	a.So(j.IsEmpty(), ShouldBeTrue)
+	j.Destroy()
+}
+
+func TestFileCorruption(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	q, err := OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Add("a"), ShouldBeNil)
+	a.So(q.Add("b"), ShouldBeNil)
+	q.Destroy()
+	good, err := ioutil.ReadFile(path)
+	a.So(err, ShouldBeNil)
+
+	// What remains of a record that extended the file but was not persisted is discarded
+	a.So(ioutil.WriteFile(path, append(append([]byte(nil), good...), make([]byte, 100)...), 0600), ShouldBeNil)
+	q, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	q.Destroy()
+	truncated, _ := ioutil.ReadFile(path)
+	a.So(truncated, ShouldResemble, good)
+
+	// A corrupt record before the end of the log is not discarded with the records after it
+	corrupt := append([]byte(nil), good...)
+	corrupt[frameHeaderSize+1]++
+	a.So(ioutil.WriteFile(path, corrupt, 0600), ShouldBeNil)
+	_, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldNotBeNil)
+	unchanged, _ := ioutil.ReadFile(path)
+	a.So(unchanged, ShouldResemble, corrupt)
+}
+
+// failingFile writes only part of the next frame
+type failingFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.fail {
+		f.fail = false
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errors.New("disk full")
+	}
+	return f.File.Write(p)
+}
+
+func TestFileWriteError(t *testing.T) {
+	a := New(t)
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	q, err := OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	log := q.(*fileSimple).log
+	file := &failingFile{File: log.file.(*os.File)}
+	log.file = file
+
+	a.So(q.Add("a"), ShouldBeNil)
+	file.fail = true
+	a.So(q.Add("b"), ShouldNotBeNil)
+	a.So(q.Add("c"), ShouldBeNil)
+	q.Destroy()
+
+	// The partially written record was removed, so the records after it are not lost
+	q, err = OpenSimple(path, DefaultFileSettings)
+	a.So(err, ShouldBeNil)
+	a.So(q.Next(), ShouldEqual, "a")
+	a.So(q.Next(), ShouldEqual, "c")
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	q.Destroy()
+}
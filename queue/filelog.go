@@ -0,0 +1,406 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	opAdd byte = iota + 1
+	opRemove
+	opReschedule
+)
+
+const (
+	flagWrapped byte = 1 << iota // the item was added with Schedule(i, time)
+)
+
+// frameHeaderSize is the size of the length and CRC that precede every record
+const frameHeaderSize = 8
+
+// maxRecordSize protects against allocating huge buffers for corrupted lengths
+const maxRecordSize = 64 << 20
+
+var errCorrupt = errors.New("queue: corrupt record")
+
+// logRecord is a record in the file log. Every record is written as a frame of a 4-byte length, a 4-byte CRC-32 of the
+// record and the record itself.
+type logRecord struct {
+	op    byte
+	id    uint64
+	time  int64
+	flags byte
+	data  []byte
+}
+
+func (r *logRecord) marshal() []byte {
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+1+2*binary.MaxVarintLen64+1+len(r.data))
+	buf = append(buf, r.op)
+	buf = appendUvarint(buf, r.id)
+	switch r.op {
+	case opAdd:
+		buf = appendVarint(buf, r.time)
+		buf = append(buf, r.flags)
+		buf = append(buf, r.data...)
+	case opReschedule:
+		buf = appendVarint(buf, r.time)
+	}
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)-frameHeaderSize))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[frameHeaderSize:]))
+	return buf
+}
+
+func (r *logRecord) unmarshal(buf []byte) error {
+	if len(buf) < 2 {
+		return errCorrupt
+	}
+	r.op, buf = buf[0], buf[1:]
+	var n int
+	if r.id, n = binary.Uvarint(buf); n <= 0 {
+		return errCorrupt
+	}
+	buf = buf[n:]
+	switch r.op {
+	case opAdd, opReschedule:
+		if r.time, n = binary.Varint(buf); n <= 0 {
+			return errCorrupt
+		}
+		buf = buf[n:]
+	case opRemove:
+	default:
+		return errCorrupt
+	}
+	if r.op == opAdd {
+		if len(buf) < 1 {
+			return errCorrupt
+		}
+		r.flags, r.data = buf[0], append([]byte(nil), buf[1:]...)
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+// readRecord reads the next record. It returns io.EOF at the end of the log, and io.ErrUnexpectedEOF or errCorrupt if
+// the log ends with a partially written or corrupted record.
+func readRecord(r io.Reader) (*logRecord, int, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return nil, 0, errCorrupt
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(buf) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errCorrupt
+	}
+	record := new(logRecord)
+	if err := record.unmarshal(buf); err != nil {
+		return nil, 0, err
+	}
+	return record, frameHeaderSize + int(size), nil
+}
+
+// logFile is the file of a fileLog
+type logFile interface {
+	io.Writer
+	io.Seeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// fileLog is an append-only log of the changes to a queue. It keeps the records of the items that are still in the
+// queue, so that the log can be compacted by rewriting only those records.
+type fileLog struct {
+	path             string
+	sync             bool
+	compactThreshold int
+
+	file    logFile
+	offset  int64 // the end of the last record that was written completely
+	err     error // set if a partially written record could not be removed, so that the log can not be written anymore
+	records int
+	live    map[uint64]*logRecord
+	lastID  uint64
+}
+
+// openLog opens the log at path and replays it. If the log ends with a partially written record, for example because
+// of a crash, the record is discarded. It returns an error if a record before the end of the log is corrupt.
+func openLog(path string, sync bool, compactThreshold int) (*fileLog, error) {
+	// A leftover compacted log was never renamed, so the original log is still complete.
+	if err := os.Remove(compactPath(path)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &fileLog{
+		path:             path,
+		sync:             sync,
+		compactThreshold: compactThreshold,
+		file:             file,
+		live:             make(map[uint64]*logRecord),
+	}
+
+	var offset int64
+	r := bufio.NewReader(file)
+	for {
+		record, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF || err == errCorrupt {
+			torn, err := tornWrite(file, offset)
+			if err == nil && !torn {
+				err = fmt.Errorf("queue: corrupt record at offset %d of %s", offset, path)
+			}
+			if err == nil {
+				err = file.Truncate(offset)
+			}
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		offset += int64(n)
+		l.apply(record)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.offset = offset
+	if err := l.maybeCompact(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// tornWrite returns true if the corrupt record at offset is the last record of the log, which was not written
+// completely: it extends to the end of the file, or it is followed by nothing but zeros, which is what remains of a
+// write that extended the file but was not persisted.
+func tornWrite(file *os.File, offset int64) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	rest := io.NewSectionReader(file, offset, info.Size()-offset)
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(rest, header[:]); err != nil {
+		// The header itself was not written completely
+		return true, nil
+	}
+	if size := binary.BigEndian.Uint32(header[0:4]); size <= maxRecordSize && offset+frameHeaderSize+int64(size) >= info.Size() {
+		return true, nil
+	}
+	buf := make([]byte, 32<<10)
+	if _, err := rest.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	for {
+		n, err := rest.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+func compactPath(path string) string {
+	return path + ".compact"
+}
+
+// apply applies a record to the live records
+func (l *fileLog) apply(r *logRecord) {
+	l.records++
+	if r.id > l.lastID {
+		l.lastID = r.id
+	}
+	switch r.op {
+	case opAdd:
+		l.live[r.id] = r
+	case opRemove:
+		delete(l.live, r.id)
+	case opReschedule:
+		if live, ok := l.live[r.id]; ok {
+			live.time = r.time
+		}
+	}
+}
+
+// pending returns the records of the items that are still in the queue, in the order in which they were added
+func (l *fileLog) pending() []*logRecord {
+	records := make([]*logRecord, 0, len(l.live))
+	for _, r := range l.live {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].id < records[j].id })
+	return records
+}
+
+func (l *fileLog) write(r *logRecord) error {
+	if l.file == nil {
+		return ErrDestroyed
+	}
+	if l.err != nil {
+		return l.err
+	}
+	frame := r.marshal()
+	if err := l.append(frame); err != nil {
+		// The next records must not be written after a partial one, as it would corrupt the log
+		if rerr := l.rollback(); rerr != nil {
+			l.err = fmt.Errorf("queue: could not remove a partially written record: %s", rerr)
+		}
+		return err
+	}
+	l.offset += int64(len(frame))
+	l.apply(r)
+	return nil
+}
+
+// append writes a frame at the end of the log
+func (l *fileLog) append(frame []byte) error {
+	if _, err := l.file.Write(frame); err != nil {
+		return err
+	}
+	if l.sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// rollback truncates the log to the end of the last record that was written completely
+func (l *fileLog) rollback() error {
+	if err := l.file.Truncate(l.offset); err != nil {
+		return err
+	}
+	_, err := l.file.Seek(l.offset, io.SeekStart)
+	return err
+}
+
+func (l *fileLog) add(id uint64, t time.Time, flags byte, data []byte) error {
+	var unix int64
+	if !t.IsZero() {
+		unix = t.UnixNano()
+	}
+	return l.write(&logRecord{op: opAdd, id: id, time: unix, flags: flags, data: data})
+}
+
+func (l *fileLog) remove(id uint64) error {
+	if err := l.write(&logRecord{op: opRemove, id: id}); err != nil {
+		return err
+	}
+	return l.maybeCompact()
+}
+
+func (l *fileLog) reschedule(id uint64, t time.Time) error {
+	return l.write(&logRecord{op: opReschedule, id: id, time: t.UnixNano()})
+}
+
+// maybeCompact compacts the log if it reached the threshold and less than half of the records are live
+func (l *fileLog) maybeCompact() error {
+	if l.compactThreshold < 0 || l.records < l.compactThreshold || l.records <= 2*len(l.live) {
+		return nil
+	}
+	return l.compact()
+}
+
+// compact rewrites the log with only the live records. The new log is written next to the old one and renamed when it
+// is complete, so that a crash leaves either the old or the new log.
+func (l *fileLog) compact() error {
+	tmpPath := compactPath(l.path)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	pending := l.pending()
+	var offset int64
+	for _, r := range pending {
+		var n int
+		if n, err = w.Write(r.marshal()); err != nil {
+			break
+		}
+		offset += int64(n)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, l.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+	l.file.Close()
+	l.file = tmp
+	l.offset = offset
+	l.records = len(pending)
+	return nil
+}
+
+// syncDir makes sure that a rename in dir is persisted
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func (l *fileLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,156 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Handle refers to an item in a JIT Queue or Schedule
+type Handle interface {
+	// Cancel removes the item from the queue. It returns false if the item is no longer in the queue.
+	Cancel() bool
+
+	// Reschedule changes the time at which the item is returned. It returns false if the item is no longer in the queue,
+	// or if the new time could not be persisted. In a Schedule, the item keeps its duration, and conflicts are
+	// calculated with the new time.
+	Reschedule(time time.Time) bool
+}
+
+type jitHandle[T any] struct {
+	q *jitQueue[T]
+	e *jitEntry[T] // nil if the item was never added
+}
+
+// handle returns a Handle for e, which may be nil if the item was not added
+func (q *jitQueue[T]) handle(e *jitEntry[T]) Handle {
+	return &jitHandle[T]{q: q, e: e}
+}
+
+func (h *jitHandle[T]) Cancel() bool {
+	if h.e == nil {
+		return false
+	}
+	q := h.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if h.e.index < 0 {
+		return false
+	}
+	first := h.e.index == 0
+	heap.Remove(&q.queue, h.e.index)
+	if q.onDelete != nil {
+		q.onDelete(h.e)
+	}
+	q.observeDropped(len(q.queue))
+	q.notifyRemoved()
+	if q.onRemove != nil {
+		q.onRemove(h.e.original)
+	}
+	if first {
+		q.notify()
+	}
+	return true
+}
+
+func (h *jitHandle[T]) Reschedule(t time.Time) bool {
+	if h.e == nil {
+		return false
+	}
+	q := h.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if h.e.index < 0 {
+		return false
+	}
+	if q.onReschedule != nil {
+		if err := q.onReschedule(h.e.original, t); err != nil {
+			return false
+		}
+	}
+	first := q.queue[0]
+	if q.onDelete != nil {
+		q.onDelete(h.e)
+	}
+	h.e.item = reschedule(h.e.original, t, q.shiftTimestamp)
+	h.e.time = t
+	heap.Fix(&q.queue, h.e.index)
+	if q.onInsert != nil {
+		q.onInsert(h.e)
+	}
+	if first == h.e || q.queue[0] == h.e {
+		q.notify()
+	}
+	return true
+}
+
+// reschedule returns an item that has the given time, but otherwise behaves like i. If shift is not nil, it is used
+// to move the timestamp of the item.
+func reschedule(i JITItem, t time.Time, shift func(int64, time.Duration) int64) JITItem {
+	if i.Time().Equal(t) {
+		return i
+	}
+	r := rescheduledItem{JITItem: i, time: t}
+	s, ok := i.(ScheduleItem)
+	if !ok {
+		return &r
+	}
+	rs := rescheduledScheduleItem{rescheduledItem: r, duration: s.Duration()}
+	if ts, ok := i.(ScheduleItemWithTimestamp); ok {
+		// The timestamp moves along with the time
+		timestamp := ts.Timestamp() + t.Sub(i.Time()).Nanoseconds()
+		if shift != nil {
+			timestamp = shift(ts.Timestamp(), t.Sub(i.Time()))
+		}
+		return &rescheduledScheduleItemWithTimestamp{
+			rescheduledScheduleItem: rs,
+			timestamp:               timestamp,
+		}
+	}
+	return &rs
+}
+
+// unwrap returns the item as it was added, before it was rescheduled
+func unwrap(i JITItem) JITItem {
+	for {
+		switch item := i.(type) {
+		case *rescheduledItem:
+			i = item.JITItem
+		case *rescheduledScheduleItem:
+			i = item.JITItem
+		case *rescheduledScheduleItemWithTimestamp:
+			i = item.JITItem
+		default:
+			return i
+		}
+	}
+}
+
+type rescheduledItem struct {
+	JITItem
+	time time.Time
+}
+
+func (i *rescheduledItem) Time() time.Time {
+	return i.time
+}
+
+type rescheduledScheduleItem struct {
+	rescheduledItem
+	duration time.Duration
+}
+
+func (i *rescheduledScheduleItem) Duration() time.Duration {
+	return i.duration
+}
+
+type rescheduledScheduleItemWithTimestamp struct {
+	rescheduledScheduleItem
+	timestamp int64
+}
+
+func (i *rescheduledScheduleItemWithTimestamp) Timestamp() int64 {
+	return i.timestamp
+}
@@ -0,0 +1,93 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func errOf(_ Handle, err error) error                                        { return err }
+func conflictsOf(_ Handle, conflicts []ScheduleItem, _ error) []ScheduleItem { return conflicts }
+func timeOf(_ Handle, t time.Time, _ error) time.Time                        { return t }
+
+func TestJITHandle(t *testing.T) {
+	a := New(t)
+	q := NewJIT()
+
+	now := time.Now()
+
+	first, _ := q.Schedule("first", now.Add(-3*time.Millisecond))
+	second, _ := q.Schedule("second", now.Add(-2*time.Millisecond))
+	q.Schedule("third", now.Add(-1*time.Millisecond))
+
+	a.So(first.Cancel(), ShouldBeTrue)
+	a.So(first.Cancel(), ShouldBeFalse)
+	a.So(first.Reschedule(now), ShouldBeFalse)
+
+	a.So(second.Reschedule(now.Add(-500*time.Microsecond)), ShouldBeTrue)
+
+	a.So(q.Next(), ShouldEqual, "third")
+	a.So(q.Next(), ShouldEqual, "second")
+	a.So(q.IsEmpty(), ShouldBeTrue)
+
+	a.So(second.Cancel(), ShouldBeFalse)
+
+	// Rescheduling wakes up a waiting consumer
+	waitTime := 20 * time.Millisecond
+	later, _ := q.Schedule("later", time.Now().Add(time.Hour))
+	go func() {
+		time.Sleep(waitTime / 2)
+		later.Reschedule(time.Now().Add(waitTime / 2))
+	}()
+	start := time.Now()
+	a.So(q.Next(), ShouldEqual, "later")
+	a.So(time.Now(), ShouldHappenWithin, 5*time.Millisecond, start.Add(waitTime))
+
+	// Handles of dropped items are not in the queue
+	q = NewJIT(WithCapacity(1, Reject))
+	q.Schedule("first", now)
+	rejected, err := q.Schedule("rejected", now)
+	a.So(err, ShouldEqual, ErrFull)
+	a.So(rejected.Cancel(), ShouldBeFalse)
+
+	q.Destroy()
+	a.So(rejected.Reschedule(now), ShouldBeFalse)
+}
+
+func TestScheduleHandle(t *testing.T) {
+	a := New(t)
+	q := NewSchedule()
+
+	now := time.Now().Add(time.Hour)
+
+	h, _, _ := q.Schedule("0", now, time.Second)
+	a.So(q.Conflicts(now, time.Second), ShouldHaveLength, 1)
+
+	// A canceled item frees its slot
+	a.So(h.Cancel(), ShouldBeTrue)
+	a.So(q.Conflicts(now, time.Second), ShouldBeEmpty)
+
+	// A rescheduled item moves its slot
+	h, _, _ = q.Schedule("1", now, time.Second)
+	a.So(h.Reschedule(now.Add(time.Minute)), ShouldBeTrue)
+	a.So(q.Conflicts(now, time.Second), ShouldBeEmpty)
+	conflicts := q.Conflicts(now.Add(time.Minute), time.Second)
+	a.So(conflicts, ShouldHaveLength, 1)
+	a.So(conflicts[0].Time(), ShouldResemble, now.Add(time.Minute))
+	a.So(conflicts[0].Duration(), ShouldEqual, time.Second)
+
+	// The timestamp moves along with the time
+	h, _, _ = q.ScheduleWithTimestamp("2", now, 1000, 10)
+	a.So(q.ConflictsForTimestamp(1005, 10), ShouldHaveLength, 1)
+	a.So(h.Reschedule(now.Add(100)), ShouldBeTrue)
+	a.So(q.ConflictsForTimestamp(1005, 10), ShouldBeEmpty)
+	a.So(q.ConflictsForTimestamp(1105, 10), ShouldHaveLength, 1)
+
+	// Next returns the original item
+	h.Reschedule(time.Now().Add(-time.Millisecond))
+	a.So(q.Next(), ShouldEqual, "2")
+}
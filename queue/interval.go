@@ -0,0 +1,240 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"math"
+	"time"
+)
+
+// intervalTree is a treap of entries, ordered by the start of their interval. Each node keeps the latest end and the
+// longest duration in its subtree, so that the entries that overlap an interval are found without visiting the others.
+type intervalTree[T any] struct {
+	root *intervalNode[T]
+	seed uint64
+}
+
+type intervalNode[T any] struct {
+	entry       *jitEntry[T]
+	start, end  int64
+	priority    uint64
+	maxEnd      int64 // of the subtree
+	maxDuration int64 // of the subtree
+	left, right *intervalNode[T]
+}
+
+// less returns true if n is ordered before the entry with sequence number seq that starts at start
+func (n *intervalNode[T]) less(start int64, seq uint64) bool {
+	if n.start == start {
+		return n.entry.seq < seq
+	}
+	return n.start < start
+}
+
+// update recalculates the latest end and the longest duration of the subtree of n
+func (n *intervalNode[T]) update() {
+	n.maxEnd, n.maxDuration = n.end, n.end-n.start
+	for _, child := range [2]*intervalNode[T]{n.left, n.right} {
+		if child == nil {
+			continue
+		}
+		if child.maxEnd > n.maxEnd {
+			n.maxEnd = child.maxEnd
+		}
+		if child.maxDuration > n.maxDuration {
+			n.maxDuration = child.maxDuration
+		}
+	}
+}
+
+// split splits the subtree of n in the nodes that are ordered before the entry with sequence number seq that starts at
+// start, and the other nodes
+func (n *intervalNode[T]) split(start int64, seq uint64) (l, r *intervalNode[T]) {
+	if n == nil {
+		return nil, nil
+	}
+	if n.less(start, seq) {
+		n.right, r = n.right.split(start, seq)
+		n.update()
+		return n, r
+	}
+	l, n.left = n.left.split(start, seq)
+	n.update()
+	return l, n
+}
+
+// merge merges the subtree of n with the subtree of r, of which all nodes are ordered after the nodes of n
+func (n *intervalNode[T]) merge(r *intervalNode[T]) *intervalNode[T] {
+	if n == nil {
+		return r
+	}
+	if r == nil {
+		return n
+	}
+	if n.priority > r.priority {
+		n.right = n.right.merge(r)
+		n.update()
+		return n
+	}
+	r.left = n.merge(r.left)
+	r.update()
+	return r
+}
+
+// overlapping calls f in order for the entries in the subtree of n of which the interval overlaps from-to
+func (n *intervalNode[T]) overlapping(from, to int64, f func(*jitEntry[T])) {
+	if n == nil || n.maxEnd < from {
+		return
+	}
+	n.left.overlapping(from, to, f)
+	if n.start > to {
+		// all nodes on the right start even later
+		return
+	}
+	if n.end >= from {
+		f(n.entry)
+	}
+	n.right.overlapping(from, to, f)
+}
+
+// nextPriority returns a pseudo-random priority for a new node (splitmix64)
+func (t *intervalTree[T]) nextPriority() uint64 {
+	t.seed += 0x9e3779b97f4a7c15
+	z := t.seed
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (t *intervalTree[T]) insert(e *jitEntry[T], start, end int64) {
+	n := &intervalNode[T]{entry: e, start: start, end: end, priority: t.nextPriority()}
+	n.update()
+	l, r := t.root.split(start, e.seq)
+	t.root = l.merge(n).merge(r)
+}
+
+// delete removes the entry that was inserted at start
+func (t *intervalTree[T]) delete(e *jitEntry[T], start int64) {
+	l, r := t.root.split(start, e.seq)
+	_, r = r.split(start, e.seq+1)
+	t.root = l.merge(r)
+}
+
+// overlapping calls f in order for the entries of which the interval overlaps from-to
+func (t *intervalTree[T]) overlapping(from, to int64, f func(*jitEntry[T])) {
+	t.root.overlapping(from, to, f)
+}
+
+// counterPeriod is the period of the 32-bit microsecond counter of a gateway
+const counterPeriod = 1 << 32
+
+// overlappingCounter calls f for the entries with an interval on the counter that overlaps from-to. As the counter
+// rolls over, the interval of an entry repeats every counterPeriod.
+func (t *intervalTree[T]) overlappingCounter(from, to int64, f func(*jitEntry[T])) {
+	if to-from >= counterPeriod {
+		t.overlapping(math.MinInt64, math.MaxInt64, f)
+		return
+	}
+	offset := from % counterPeriod
+	if offset < 0 {
+		offset += counterPeriod
+	}
+	base := from - offset
+	for _, shift := range [3]int64{base - counterPeriod, base, base + counterPeriod} {
+		t.overlapping(from-shift, to-shift, f)
+	}
+}
+
+// maxDuration returns the longest duration of the entries
+func (t *intervalTree[T]) maxDuration() int64 {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.maxDuration
+}
+
+// scheduleIndex is an ordered index of the queued items of a Schedule. Items are indexed by time, and items with a
+// timestamp also by timestamp, so that conflicts and free slots are found without visiting all items.
+type scheduleIndex[T any] struct {
+	byTime      intervalTree[T]
+	byTimestamp intervalTree[T]
+
+	// counter is true if the timestamps are of the 32-bit microsecond counter of a gateway (see SyncedSchedule)
+	counter bool
+}
+
+// timeInterval returns the interval of an item in nanoseconds
+func timeInterval(i ScheduleItem) (start, end int64) {
+	start = i.Time().UnixNano()
+	return start, start + i.Duration().Nanoseconds()
+}
+
+// timestampInterval returns the interval of an item in the unit of its timestamp, and false if it has no timestamp
+func (x *scheduleIndex[T]) timestampInterval(i ScheduleItem) (start, end int64, ok bool) {
+	ts, ok := i.(ScheduleItemWithTimestamp)
+	if !ok {
+		return 0, 0, false
+	}
+	if x.counter {
+		start = int64(uint32(ts.Timestamp()))
+		return start, start + int64(i.Duration()/time.Microsecond), true
+	}
+	return ts.Timestamp(), ts.Timestamp() + i.Duration().Nanoseconds(), true
+}
+
+// insert adds the item of an entry to the index, the entry must be removed before its item is changed
+func (x *scheduleIndex[T]) insert(e *jitEntry[T]) {
+	item, ok := e.item.(ScheduleItem)
+	if !ok {
+		return
+	}
+	start, end := timeInterval(item)
+	x.byTime.insert(e, start, end)
+	if start, end, ok := x.timestampInterval(item); ok {
+		x.byTimestamp.insert(e, start, end)
+	}
+}
+
+func (x *scheduleIndex[T]) remove(e *jitEntry[T]) {
+	item, ok := e.item.(ScheduleItem)
+	if !ok {
+		return
+	}
+	start, _ := timeInterval(item)
+	x.byTime.delete(e, start)
+	if start, _, ok := x.timestampInterval(item); ok {
+		x.byTimestamp.delete(e, start)
+	}
+}
+
+// candidates calls f for the entries that may conflict with i in the given order, which include all entries that do.
+// Entries may be passed more than once.
+func (x *scheduleIndex[T]) candidates(o order, i ScheduleItem, f func(*jitEntry[T])) {
+	// Items without a timestamp, or of which the timestamp is not compared, are compared by time
+	start := o.timeOf(i).UnixNano()
+	end := start + i.Duration().Nanoseconds()
+	x.byTime.overlapping(start, end, f)
+
+	if from, to, ok := x.timestampInterval(i); ok {
+		// Timestamps are compared if both items have one
+		if x.counter {
+			x.byTimestamp.overlappingCounter(from, to, f)
+		} else {
+			x.byTimestamp.overlapping(from, to, f)
+		}
+		return
+	}
+
+	if o.clock == nil || !o.clock.synced {
+		return
+	}
+	// The timestamps of the other items are converted to time
+	m := o.clock.model
+	if m.slope <= 0 {
+		x.byTimestamp.overlapping(math.MinInt64, math.MaxInt64, f)
+		return
+	}
+	longest := time.Duration(x.byTimestamp.maxDuration()+1) * time.Microsecond
+	x.byTimestamp.overlappingCounter(m.timestamp(start-longest.Nanoseconds())-1, m.timestamp(end)+1, f)
+}
@@ -0,0 +1,106 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+// scanConflicts returns the conflicts of i by checking all items, like the Schedule did before it had an index
+func scanConflicts(q *schedule[interface{}], i ScheduleItem) map[*jitEntry[interface{}]]bool {
+	o := q.order()
+	conflicts := make(map[*jitEntry[interface{}]]bool)
+	for _, e := range q.last {
+		if o.conflict(i, e.item.(ScheduleItem)) {
+			conflicts[e] = true
+		}
+	}
+	for _, e := range q.queue {
+		if o.conflict(i, e.item.(ScheduleItem)) {
+			conflicts[e] = true
+		}
+	}
+	return conflicts
+}
+
+func TestScheduleIndex(t *testing.T) {
+	a := New(t)
+
+	now := time.Now()
+	c := NewClockSync(DefaultClockSyncSettings)
+	ts := uint32(math.MaxUint32 - 5000000) // rolls over in 5 seconds
+	counter := func(t time.Time) uint32 { return ts + uint32(float64(t.Sub(now)/time.Microsecond)*(1+50e-6)) }
+	c.Sync(counter(now.Add(-time.Minute)), now.Add(-time.Minute))
+	c.Sync(counter(now), now)
+
+	for _, q := range []itemSchedule{
+		NewSchedule().(itemSchedule),
+		NewMultiSchedule().(itemSchedule),
+		NewSyncedSchedule(c).(itemSchedule),
+	} {
+		r := rand.New(rand.NewSource(42))
+		resources := []string{"a", "b", "c"}
+		random := func() ScheduleItem {
+			at := now.Add(time.Duration(r.Int63n(int64(20 * time.Second))))
+			duration := time.Duration(r.Int63n(int64(time.Second)))
+			switch r.Intn(3) {
+			case 0:
+				return newItem(resources[r.Intn(len(resources)):], "", at, duration)
+			case 1:
+				// The timestamp is compared with the timestamps of other items, the time only with the time of items
+				// without a timestamp, or not at all if the clock is synchronized
+				timestamp := now.Add(time.Duration(r.Int63n(int64(20 * time.Second))))
+				item := &scheduleItemWithTimestamp{timestamp: timestamp.UnixNano()}
+				if q.clock != nil {
+					item.timestamp = int64(counter(timestamp))
+				}
+				item.time, item.duration = at, duration
+				return item
+			default:
+				return newItem(nil, "", at, duration)
+			}
+		}
+
+		var handles []Handle
+		for n := 0; n < 1000; n++ {
+			switch r.Intn(10) {
+			case 0:
+				if len(handles) > 0 {
+					handles[r.Intn(len(handles))].Cancel()
+				}
+			case 1:
+				if len(handles) > 0 {
+					handles[r.Intn(len(handles))].Reschedule(now.Add(time.Duration(r.Int63n(int64(20 * time.Second)))))
+				}
+			case 2:
+				q.mu.Lock()
+				if !q.isEmpty() {
+					q.pop()
+				}
+				q.mu.Unlock()
+			default:
+				h, _, _ := q.Add(random())
+				handles = append(handles, h)
+			}
+
+			probe := random()
+			q.mu.Lock()
+			expected := scanConflicts(q.schedule, probe)
+			conflicts := q.conflicts(probe)
+			q.mu.Unlock()
+			a.So(conflicts, ShouldHaveLength, len(expected))
+			for _, e := range conflicts {
+				a.So(expected[e], ShouldBeTrue)
+			}
+		}
+		q.Destroy()
+		a.So(q.index.byTime.root, ShouldBeNil)
+		a.So(q.index.byTimestamp.root, ShouldBeNil)
+	}
+}
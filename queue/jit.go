@@ -4,7 +4,8 @@
 package queue
 
 import (
-	"sort"
+	"container/heap"
+	"context"
 	"sync"
 	"time"
 )
@@ -14,14 +15,7 @@ type JITItem interface {
 	Time() time.Time
 }
 
-type item interface{}
-
-type hasItem interface {
-	getItem() item
-}
-
 type jitItem struct {
-	item interface{}
 	time time.Time
 }
 
@@ -29,147 +23,331 @@ func (i *jitItem) Time() time.Time {
 	return i.time
 }
 
-func (i *jitItem) getItem() item {
-	return i.item
-}
-
 // JIT is a just-in-time implementation of the Queue. It allows setting a time for each item in the queue. The item will
 // be returned by Next() immediately after this time.
 type JIT interface {
 	Base
 
 	// Add an Item to the JIT Queue, will be returned by Next() at item.Time()
-	Add(item JITItem)
+	// If the Queue is full, the overflow policy is applied. It returns ErrFull if the item is rejected, and
+	// ErrDestroyed if the Queue is destroyed. The returned Handle can be used to cancel or reschedule the item.
+	Add(item JITItem) (Handle, error)
 
 	// Schedule an Item to the JIT Queue, will be returned by Next() at time
-	Schedule(i interface{}, time time.Time)
+	// If the Queue is full, the overflow policy is applied. It returns ErrFull if the item is rejected, and
+	// ErrDestroyed if the Queue is destroyed. The returned Handle can be used to cancel or reschedule the item.
+	Schedule(i interface{}, time time.Time) (Handle, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Queue was full
+	Dropped() uint64
+}
+
+// JITOf is the type-safe variant of JIT, for items of type T
+type JITOf[T any] interface {
+	BaseOf[T]
+
+	// Schedule an item to the JIT Queue, will be returned by Next() at time
+	// If the Queue is full, the overflow policy is applied. It returns ErrFull if the item is rejected, and
+	// ErrDestroyed if the Queue is destroyed. The returned Handle can be used to cancel or reschedule the item.
+	Schedule(item T, time time.Time) (Handle, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Queue was full
+	Dropped() uint64
 }
 
-type jitSlice []JITItem
+type jitEntry[T any] struct {
+	item     JITItem
+	original JITItem // the item as it was added, before it was rescheduled
+	value    T       // the item that is returned by Next()
+	time     time.Time
+	added    time.Time
+	seq      uint64
+	index    int // index in the heap, -1 if the item is no longer in the queue
+}
 
-func (s jitSlice) Len() int           { return len(s) }
-func (s jitSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
-func (s jitSlice) Less(i, j int) bool { return s[i].Time().Before(s[j].Time()) }
+// jitHeap is a min-heap of items, ordered by time. Items with the same time are ordered by the order in which they were
+// added.
+type jitHeap[T any] []*jitEntry[T]
 
-type jitQueue struct {
-	nextMu sync.Mutex
+func (h jitHeap[T]) Len() int { return len(h) }
+func (h jitHeap[T]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h jitHeap[T]) Less(i, j int) bool {
+	if h[i].time.Equal(h[j].time) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].time.Before(h[j].time)
+}
+func (h *jitHeap[T]) Push(x interface{}) {
+	e := x.(*jitEntry[T])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *jitHeap[T]) Pop() interface{} {
+	old := *h
+	last := len(old) - 1
+	e := old[last]
+	old[last] = nil
+	e.index = -1
+	*h = old[:last]
+	return e
+}
+
+type jitQueue[T any] struct {
+	bounds
 
 	mu    sync.Mutex
-	queue jitSlice
+	queue jitHeap[T]
+	seq   uint64
 
 	changed chan struct{}
+
+	// onNext is called (while locked) for every item that is returned
+	onNext func(*jitEntry[T])
+
+	// onAdd is called (while locked) before an item is added, the item is not added if it returns an error
+	onAdd func(JITItem) error
+
+	// onRemove is called (while locked) with the original item for every item that is returned, dropped or canceled
+	onRemove func(JITItem)
+
+	// onReschedule is called (while locked) with the original item before an item is rescheduled, the item is not
+	// rescheduled if it returns an error
+	onReschedule func(JITItem, time.Time) error
+
+	// onInsert and onDelete are called (while locked) when an entry is inserted in or deleted from the queue, and around
+	// changes to its item, so that another index of the entries can be kept
+	onInsert, onDelete func(*jitEntry[T])
+
+	// shiftTimestamp moves the timestamp of an item that is rescheduled, nil for timestamps in nanoseconds
+	shiftTimestamp func(timestamp int64, by time.Duration) int64
 }
 
 // NewJIT returns a new JIT Queue (see JIT interface)
-func NewJIT() JIT {
-	return &jitQueue{
-		queue:   make([]JITItem, 0),
+func NewJIT(opts ...Option) JIT {
+	return jit{newJIT[interface{}](opts)}
+}
+
+// NewJITOf returns a new JIT Queue for items of type T
+func NewJITOf[T any](opts ...Option) JITOf[T] {
+	return newJIT[T](opts)
+}
+
+func newJIT[T any](opts []Option) *jitQueue[T] {
+	return &jitQueue[T]{
+		bounds:  newBounds(opts),
+		queue:   make(jitHeap[T], 0),
 		changed: make(chan struct{}),
 	}
 }
 
-func (q *jitQueue) Add(i JITItem) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-	q.add(i)
+// jit is a JIT Queue of interface{} items, to which JITItems can also be added
+type jit struct {
+	*jitQueue[interface{}]
 }
 
-func (q *jitQueue) add(i JITItem) {
+func (q jit) Add(i JITItem) (Handle, error) {
+	return q.push(i, i)
+}
+
+// push adds the item i, which is returned as v, if there is room according to the overflow policy
+func (q *jitQueue[T]) push(i JITItem, v T) (Handle, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	if q.changed == nil {
-		return
+		return q.handle(nil), ErrDestroyed
+	}
+	if err := q.makeRoom(q.overflow); err != nil {
+		if err == errDropped {
+			return q.handle(nil), nil
+		}
+		return q.handle(nil), err
 	}
+	if q.changed == nil {
+		return q.handle(nil), ErrDestroyed
+	}
+	if q.onAdd != nil {
+		if err := q.onAdd(i); err != nil {
+			return q.handle(nil), err
+		}
+	}
+	return q.add(i, v), nil
+}
 
-	q.queue = append(q.queue, i)
-	first := q.queue[0]
+// makeRoom makes room for a new item according to the overflow policy, q.mu must be locked.
+func (q *jitQueue[T]) makeRoom(overflow OverflowPolicy) error {
+	return q.bounds.makeRoom(&q.mu, overflow, q.len, q.dropFirst)
+}
 
-	// Don't sort when item was appended chronologically (in existing queue)
-	if len(q.queue) > 1 && q.queue[len(q.queue)-1].Time().After(q.queue[len(q.queue)-2].Time()) {
-		return
+// add inserts the item i, which is returned as v, q.mu must be locked
+func (q *jitQueue[T]) add(i JITItem, v T) Handle {
+	if q.changed == nil {
+		return q.handle(nil)
 	}
 
-	sort.Sort(q.queue)
+	q.seq++
+	e := &jitEntry[T]{item: i, original: i, value: v, time: i.Time(), added: time.Now(), seq: q.seq}
+	heap.Push(&q.queue, e)
+	if q.onInsert != nil {
+		q.onInsert(e)
+	}
+	q.observeEnqueued(len(q.queue))
 
-	// don't notify if first item (in existing queue) unchanged
-	if len(q.queue) > 1 && first == q.queue[0] {
-		return
+	// only notify if the first item changed
+	if q.queue[0] == e {
+		q.notify()
 	}
 
+	return q.handle(e)
+}
+
+// notify wakes up consumers, q.mu must be locked
+func (q *jitQueue[T]) notify() {
 	close(q.changed)
 	q.changed = make(chan struct{})
 }
 
-func (q *jitQueue) Schedule(i interface{}, time time.Time) {
-	q.Add(&jitItem{item: i, time: time})
+func (q *jitQueue[T]) Schedule(i T, time time.Time) (Handle, error) {
+	return q.push(&jitItem{time: time}, i)
+}
+
+func (q *jitQueue[T]) Next() T {
+	i, _ := q.NextContext(context.Background())
+	return i
 }
 
-func (q *jitQueue) Next() interface{} {
-	q.nextMu.Lock()
-	defer q.nextMu.Unlock()
-	next := q.next()
-	if next == nil {
-		return nil
+func (q *jitQueue[T]) NextContext(ctx context.Context) (T, error) {
+	next, err := q.next(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
 	}
-	if next, ok := next.(hasItem); ok {
-		return next.getItem()
+	return next.value, nil
+}
+
+func (q *jitQueue[T]) TryNext() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil || q.isEmpty() || q.queue[0].time.After(time.Now()) {
+		var zero T
+		return zero, false
 	}
-	return next
+	return q.pop().value, true
 }
 
-func (q *jitQueue) next() interface{} {
-	var i JITItem
+// pop removes the first item, q.mu must be locked
+func (q *jitQueue[T]) pop() *jitEntry[T] {
+	e := heap.Pop(&q.queue).(*jitEntry[T])
+	if q.onDelete != nil {
+		q.onDelete(e)
+	}
+	q.observeLate(e.time)
+	q.observeDequeued(len(q.queue), e.added)
+	q.notifyRemoved()
+	if q.onRemove != nil {
+		q.onRemove(e.original)
+	}
+	if q.onNext != nil {
+		q.onNext(e)
+	}
+	return e
+}
+
+// dropFirst drops the first item, q.mu must be locked
+func (q *jitQueue[T]) dropFirst() {
+	e := heap.Pop(&q.queue).(*jitEntry[T])
+	if q.onDelete != nil {
+		q.onDelete(e)
+	}
+	q.notifyRemoved()
+	if q.onRemove != nil {
+		q.onRemove(e.original)
+	}
+}
+
+func (q *jitQueue[T]) len() int {
+	return len(q.queue)
+}
+
+func (q *jitQueue[T]) next(ctx context.Context) (*jitEntry[T], error) {
 	for {
+		var (
+			e  *jitEntry[T]
+			at time.Time
+		)
 		q.mu.Lock()
 		if q.changed == nil {
 			q.mu.Unlock()
-			return nil
+			return nil, ErrDestroyed
 		}
 		changed := q.changed
 		if !q.isEmpty() {
-			i = q.queue[0]
+			e, at = q.queue[0], q.queue[0].time
 			// immediately send expired items
-			if i.Time().Before(time.Now()) {
+			if at.Before(time.Now()) {
 				defer q.mu.Unlock()
-				q.queue = q.queue[1:]
-				return i
+				return q.pop(), nil
 			}
 		}
 		q.mu.Unlock()
 
-		if i == nil {
-			<-changed
+		if e == nil {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-changed:
+			}
 			continue
 		}
 
+		timer := time.NewTimer(time.Until(at))
 		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
 		case <-changed:
+			timer.Stop()
 			continue
-		case <-time.After(time.Until(i.Time())):
+		case <-timer.C:
 			q.mu.Lock()
-			if !q.isEmpty() && i == q.queue[0] {
+			if !q.isEmpty() && e == q.queue[0] {
 				defer q.mu.Unlock()
-				q.queue = q.queue[1:]
-				return i
+				return q.pop(), nil
 			}
-			// this is highly unlikely
+			// another consumer was first
 			q.mu.Unlock()
 		}
 	}
 }
 
-func (q *jitQueue) IsEmpty() bool {
+func (q *jitQueue[T]) IsEmpty() bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	return q.isEmpty()
 }
 
-func (q *jitQueue) isEmpty() bool {
+func (q *jitQueue[T]) isEmpty() bool {
 	return len(q.queue) == 0
 }
 
-func (q *jitQueue) Destroy() {
+func (q *jitQueue[T]) Destroy() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.queue = make([]JITItem, 0)
+	if q.changed == nil {
+		return
+	}
+	for _, e := range q.queue {
+		e.index = -1
+		if q.onDelete != nil {
+			q.onDelete(e)
+		}
+	}
+	q.queue = make(jitHeap[T], 0)
 	close(q.changed)
 	q.changed = nil
+	q.notifyRemoved()
+	q.observeDestroyed()
 }
@@ -0,0 +1,81 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// sortedJIT is the previous implementation of the JIT queue storage, which is kept for comparison in the benchmarks
+type sortedJIT []JITItem
+
+func (s sortedJIT) Len() int           { return len(s) }
+func (s sortedJIT) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortedJIT) Less(i, j int) bool { return s[i].Time().Before(s[j].Time()) }
+
+func (s *sortedJIT) add(i JITItem) {
+	*s = append(*s, i)
+	if len(*s) > 1 && (*s)[len(*s)-1].Time().After((*s)[len(*s)-2].Time()) {
+		return
+	}
+	sort.Sort(*s)
+}
+
+func (s *sortedJIT) pop() JITItem {
+	i := (*s)[0]
+	*s = (*s)[1:]
+	return i
+}
+
+type jitStorage interface {
+	add(i JITItem)
+	pop() JITItem
+}
+
+type heapJIT struct{ q *jitQueue[interface{}] }
+
+func (h heapJIT) add(i JITItem) { h.q.add(i, i) }
+func (h heapJIT) pop() JITItem  { return h.q.pop().item }
+
+var jitBenchmarkSizes = []int{100, 1000, 10000}
+
+func benchmarkItems(n int) []JITItem {
+	now := time.Now()
+	r := rand.New(rand.NewSource(42))
+	items := make([]JITItem, n)
+	for i := range items {
+		items[i] = &jitItem{time: now.Add(time.Duration(r.Int63n(int64(time.Hour))))}
+	}
+	return items
+}
+
+func benchmarkJIT(b *testing.B, newStorage func() jitStorage) {
+	for _, size := range jitBenchmarkSizes {
+		size := size
+		b.Run(fmt.Sprintf("AddPop/%d", size), func(b *testing.B) {
+			s := newStorage()
+			for _, i := range benchmarkItems(size) {
+				s.add(i)
+			}
+			items := benchmarkItems(b.N)
+			b.ResetTimer()
+			for _, i := range items {
+				s.add(i)
+				s.pop()
+			}
+		})
+	}
+}
+
+func BenchmarkJITHeap(b *testing.B) {
+	benchmarkJIT(b, func() jitStorage { return heapJIT{newJIT[interface{}](nil)} })
+}
+
+func BenchmarkJITSorted(b *testing.B) {
+	benchmarkJIT(b, func() jitStorage { return new(sortedJIT) })
+}
@@ -69,3 +69,23 @@ func TestJITQueue(t *testing.T) {
 
 	q.Schedule("too late", time.Now()) // nothing should happen
 }
+
+func TestJITOf(t *testing.T) {
+	a := New(t)
+
+	q := NewJITOf[string]()
+	now := time.Now()
+	q.Schedule("second", now.Add(2*time.Millisecond))
+	h, err := q.Schedule("first", now.Add(time.Hour))
+	a.So(err, ShouldBeNil)
+	a.So(h.Reschedule(now.Add(time.Millisecond)), ShouldBeTrue)
+	a.So(q.Next(), ShouldEqual, "first")
+	a.So(q.Next(), ShouldEqual, "second")
+	_, ok := q.TryNext()
+	a.So(ok, ShouldBeFalse)
+	a.So(q.Dropped(), ShouldEqual, 0)
+
+	// Values are returned as the zero value when the Queue is destroyed
+	go q.Destroy()
+	a.So(q.Next(), ShouldEqual, "")
+}
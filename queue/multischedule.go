@@ -0,0 +1,115 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import "time"
+
+// ResourceItem is a ScheduleItem that uses one or more resources, such as the radio chains of a gateway. It only
+// conflicts with items that use one of the same resources. Items that are not a ResourceItem use all resources.
+type ResourceItem interface {
+	ScheduleItem
+	Resources() []string
+}
+
+type resourceItem struct {
+	scheduleItem
+	resources []string
+}
+
+func (i *resourceItem) Resources() []string {
+	return i.resources
+}
+
+// resourcesOf returns the resources of an item, or nil if it uses all resources
+func resourcesOf(i JITItem) []string {
+	if item, ok := unwrap(i).(ResourceItem); ok && len(item.Resources()) > 0 {
+		return item.Resources()
+	}
+	return nil
+}
+
+func shareResources(i, j ScheduleItem) bool {
+	iResources, jResources := resourcesOf(i), resourcesOf(j)
+	if iResources == nil || jResources == nil {
+		return true
+	}
+	for _, i := range iResources {
+		for _, j := range jResources {
+			if i == j {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MultiSchedule is a Schedule for multiple resources that can be used concurrently, such as the radio chains of a
+// gateway. Items only conflict with items that use the same resource (see ResourceItem). The methods of Schedule
+// add items that use all resources, as do the methods of MultiSchedule if they are called without resources.
+type MultiSchedule interface {
+	Schedule
+
+	// ConflictsOn returns the conflicts on the given resources based on time and duration
+	ConflictsOn(resources []string, time time.Time, duration time.Duration) []ScheduleItem
+
+	// ScheduleOn schedules an item on the given resources at the given time, with the given duration
+	// this func returns the conflicts on those resources, and the same errors as Add
+	ScheduleOn(i interface{}, resources []string, time time.Time, duration time.Duration) (Handle, []ScheduleItem, error)
+
+	// ScheduleASAPOn schedules an item as soon as possible on one of the given resources, given its duration and
+	// considering the existing Schedule. If multiple resources are free at the same time, the first one is used.
+	// this func returns the resource and the time at which the item is scheduled, and the same errors as ScheduleASAP
+	ScheduleASAPOn(i interface{}, resources []string, duration time.Duration) (Handle, string, time.Time, error)
+
+	// ScheduleWithinOn schedules an item in the first free slot within the window on one of the given resources (see
+	// ScheduleWithin). If multiple resources are free at the same time, the first one is used.
+	// this func returns the resource and the time at which the item is scheduled
+	ScheduleWithinOn(i interface{}, resources []string, earliest, latest time.Time, duration time.Duration) (Handle, string, time.Time, error)
+}
+
+// NewMultiSchedule returns a new MultiSchedule (see MultiSchedule interface)
+func NewMultiSchedule(opts ...Option) MultiSchedule {
+	return itemSchedule{newSchedule[interface{}](opts)}
+}
+
+func (q itemSchedule) ConflictsOn(resources []string, time time.Time, duration time.Duration) []ScheduleItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return items(q.conflicts(newItem(resources, "", time, duration)))
+}
+
+func (q itemSchedule) ScheduleOn(i interface{}, resources []string, time time.Time, duration time.Duration) (Handle, []ScheduleItem, error) {
+	h, conflicts, err := q.add(newItem(resources, "", time, duration), i)
+	return h, items(conflicts), err
+}
+
+// options returns every resource as an option for scheduling an item, or all resources if there are none
+func options(resources []string) [][]string {
+	if len(resources) == 0 {
+		return [][]string{nil}
+	}
+	options := make([][]string, len(resources))
+	for i, resource := range resources {
+		options[i] = []string{resource}
+	}
+	return options
+}
+
+// resource returns the resource of an item that was scheduled on a single resource
+func resource(resources []string) string {
+	if len(resources) == 0 {
+		return ""
+	}
+	return resources[0]
+}
+
+func (q itemSchedule) ScheduleASAPOn(i interface{}, resources []string, duration time.Duration) (Handle, string, time.Time, error) {
+	h, scheduled, t, err := q.scheduleASAP(i, options(resources), "", duration)
+	return h, resource(scheduled), t, err
+}
+
+func (q itemSchedule) ScheduleWithinOn(i interface{}, resources []string, earliest, latest time.Time, duration time.Duration) (Handle, string, time.Time, error) {
+	h, scheduled, t, conflicts, err := q.scheduleWithin(i, options(resources), "", earliest, latest, duration)
+	return h, resource(scheduled), t, conflictError(conflicts, err)
+}
@@ -0,0 +1,79 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestMultiSchedule(t *testing.T) {
+	block := 2 * time.Second
+
+	a := New(t)
+
+	now := time.Now().Add(time.Hour)
+	at := func(blocks float64) time.Time { return now.Add(time.Duration(blocks * float64(block))) }
+
+	q := NewMultiSchedule()
+
+	// Items on different resources do not conflict
+	a.So(conflictsOf(q.ScheduleOn("a0", []string{"a"}, at(0), block)), ShouldBeEmpty)
+	a.So(conflictsOf(q.ScheduleOn("b0", []string{"b"}, at(0), block)), ShouldBeEmpty)
+	a.So(conflictsOf(q.ScheduleOn("a1", []string{"a"}, at(0.5), block)), ShouldHaveLength, 1)
+	a.So(q.ConflictsOn([]string{"b"}, at(0.5), block), ShouldHaveLength, 1)
+	a.So(q.ConflictsOn([]string{"c"}, at(0.5), block), ShouldBeEmpty)
+	a.So(q.ConflictsOn([]string{"b", "c"}, at(0.5), block), ShouldHaveLength, 1)
+
+	// Items without resources conflict with all items
+	a.So(q.Conflicts(at(0.5), block), ShouldHaveLength, 3)
+	a.So(conflictsOf(q.Schedule("all", at(5), block)), ShouldBeEmpty)
+	a.So(q.ConflictsOn([]string{"c"}, at(5), block), ShouldHaveLength, 1)
+
+	// The resource with the earliest free slot is used
+	_, resource, t0, err := q.ScheduleWithinOn("x", []string{"a", "b", "c"}, at(0), at(5), block)
+	a.So(err, ShouldBeNil)
+	a.So(resource, ShouldEqual, "c")
+	a.So(t0, ShouldEqual, at(0))
+
+	_, resource, t0, err = q.ScheduleASAPOn("now", []string{"a", "b"}, block)
+	a.So(err, ShouldBeNil)
+	a.So(resource, ShouldEqual, "a")
+	a.So(t0, ShouldHappenWithin, time.Millisecond, time.Now())
+
+	_, resource, t0, err = q.ScheduleWithinOn("y", []string{"a", "b"}, at(0), at(5), block)
+	a.So(err, ShouldBeNil)
+	a.So(resource, ShouldEqual, "b")
+	a.So(t0, ShouldHappenWithin, time.Microsecond, at(1))
+
+	_, _, _, err = q.ScheduleWithinOn("z", []string{"a", "b"}, at(0), at(2), block)
+	a.So(err, ShouldHaveSameTypeAs, &ConflictError{})
+	a.So(err.(*ConflictError).Conflicts, ShouldHaveLength, 4)
+
+	// Rescheduled items keep their resources
+	h, _, _ := q.ScheduleOn("moved", []string{"d"}, at(10), block)
+	h.Reschedule(at(20))
+	a.So(q.ConflictsOn([]string{"d"}, at(20), block), ShouldHaveLength, 1)
+	a.So(q.ConflictsOn([]string{"e"}, at(20), block), ShouldBeEmpty)
+}
+
+func TestMultiScheduleLast(t *testing.T) {
+	block := 20 * time.Millisecond
+
+	a := New(t)
+
+	q := NewMultiSchedule()
+	now := time.Now()
+	q.ScheduleOn("a", []string{"a"}, now.Add(-block/2), block)
+	q.ScheduleOn("b", []string{"b"}, now.Add(-block/4), block)
+	q.Next()
+	q.Next()
+
+	// Items that were returned still conflict on their own resource
+	a.So(q.ConflictsOn([]string{"a"}, now, block), ShouldHaveLength, 1)
+	a.So(q.ConflictsOn([]string{"b"}, now, block), ShouldHaveLength, 1)
+	a.So(q.Conflicts(now, block), ShouldHaveLength, 2)
+}
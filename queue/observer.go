@@ -0,0 +1,65 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import "time"
+
+// Observer observes the items in a Queue, for example to export metrics (see the queue/prometheus package). Its methods
+// are called while the Queue is locked, so they should return quickly. The depth is the number of items in the Queue
+// after the event.
+type Observer interface {
+	// Enqueued is called when an item is added to the Queue
+	Enqueued(depth int)
+
+	// Dequeued is called when an item is returned by the Queue, with the time that it waited in the Queue
+	Dequeued(depth int, wait time.Duration)
+
+	// Late is called by JIT Queues and Schedules when an item is returned, with the time between item.Time() and the
+	// time at which it was returned
+	Late(late time.Duration)
+
+	// Dropped is called when an item is removed without being returned: when it is dropped or rejected because the
+	// Queue is full, or when it is canceled
+	Dropped(depth int)
+
+	// Destroyed is called when the Queue is destroyed, which discards the items in it
+	Destroyed()
+}
+
+// WithObserver reports the events of the Queue to the Observer. Queues that are stored in Redis can not be observed.
+func WithObserver(observer Observer) Option {
+	return func(b *bounds) {
+		b.observer = observer
+	}
+}
+
+func (b *bounds) observeEnqueued(depth int) {
+	if b.observer != nil {
+		b.observer.Enqueued(depth)
+	}
+}
+
+func (b *bounds) observeDequeued(depth int, added time.Time) {
+	if b.observer != nil {
+		b.observer.Dequeued(depth, time.Since(added))
+	}
+}
+
+func (b *bounds) observeLate(t time.Time) {
+	if b.observer != nil {
+		b.observer.Late(time.Since(t))
+	}
+}
+
+func (b *bounds) observeDropped(depth int) {
+	if b.observer != nil {
+		b.observer.Dropped(depth)
+	}
+}
+
+func (b *bounds) observeDestroyed() {
+	if b.observer != nil {
+		b.observer.Destroyed()
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+type testObserver struct {
+	depth     int
+	enqueued  int
+	dequeued  int
+	dropped   int
+	destroyed bool
+	wait      []time.Duration
+	late      []time.Duration
+}
+
+func (o *testObserver) Enqueued(depth int) {
+	o.depth = depth
+	o.enqueued++
+}
+
+func (o *testObserver) Dequeued(depth int, wait time.Duration) {
+	o.depth = depth
+	o.dequeued++
+	o.wait = append(o.wait, wait)
+}
+
+func (o *testObserver) Late(late time.Duration) {
+	o.late = append(o.late, late)
+}
+
+func (o *testObserver) Dropped(depth int) {
+	o.depth = depth
+	o.dropped++
+}
+
+func (o *testObserver) Destroyed() {
+	o.depth = 0
+	o.destroyed = true
+}
+
+func TestObserveSimple(t *testing.T) {
+	a := New(t)
+
+	o := new(testObserver)
+	q := NewSimple(WithCapacity(2, DropOldest), WithObserver(o))
+	q.Add(1)
+	q.Add(2)
+	a.So(o.depth, ShouldEqual, 2)
+	q.Add(3)
+	a.So(o.dropped, ShouldEqual, 1)
+	a.So(o.depth, ShouldEqual, 2)
+	time.Sleep(10 * time.Millisecond)
+	a.So(q.Next(), ShouldEqual, 2)
+	a.So(o.enqueued, ShouldEqual, 3)
+	a.So(o.dequeued, ShouldEqual, 1)
+	a.So(o.depth, ShouldEqual, 1)
+	a.So(o.wait[0], ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+	a.So(o.late, ShouldBeEmpty)
+
+	o = new(testObserver)
+	r := NewSimple(WithCapacity(1, Reject), WithObserver(o))
+	r.Add(1)
+	a.So(r.Add(2), ShouldEqual, ErrFull)
+	a.So(o.dropped, ShouldEqual, 1)
+	a.So(o.depth, ShouldEqual, 1)
+	r.Destroy()
+	a.So(o.destroyed, ShouldBeTrue)
+	a.So(o.depth, ShouldEqual, 0)
+}
+
+func TestObserveJIT(t *testing.T) {
+	a := New(t)
+
+	o := new(testObserver)
+	q := NewJIT(WithObserver(o))
+	now := time.Now()
+	q.Schedule("late", now.Add(-10*time.Millisecond))
+	h, _ := q.Schedule("canceled", now.Add(time.Hour))
+	a.So(o.depth, ShouldEqual, 2)
+	a.So(q.Next(), ShouldEqual, "late")
+	a.So(o.late, ShouldHaveLength, 1)
+	a.So(o.late[0], ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+	a.So(o.depth, ShouldEqual, 1)
+	h.Cancel()
+	a.So(o.dropped, ShouldEqual, 1)
+	a.So(o.depth, ShouldEqual, 0)
+
+	o = new(testObserver)
+	s := NewSchedule(WithObserver(o))
+	s.ScheduleASAP("asap", time.Millisecond)
+	a.So(s.Next(), ShouldEqual, "asap")
+	a.So(o.enqueued, ShouldEqual, 1)
+	a.So(o.dequeued, ShouldEqual, 1)
+	a.So(o.late, ShouldHaveLength, 1)
+}
+
+func TestObservePriority(t *testing.T) {
+	a := New(t)
+
+	o := new(testObserver)
+	q := NewPriority(0, WithObserver(o))
+	q.Add("low", 0)
+	q.Add("high", 1)
+	a.So(o.depth, ShouldEqual, 2)
+	a.So(q.Next(), ShouldEqual, "high")
+	a.So(o.dequeued, ShouldEqual, 1)
+	a.So(o.depth, ShouldEqual, 1)
+}
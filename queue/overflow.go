@@ -0,0 +1,106 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrFull is returned when an item is rejected because the queue is full
+var ErrFull = errors.New("queue: full")
+
+// errDropped is used internally when the new item is dropped
+var errDropped = errors.New("queue: dropped")
+
+// OverflowPolicy determines what happens when an item is added to a full queue
+type OverflowPolicy int
+
+const (
+	// Block the producer until there is room in the queue
+	Block OverflowPolicy = iota
+
+	// DropOldest drops the item that would be returned next to make room for the new item
+	DropOldest
+
+	// DropNewest drops the new item
+	DropNewest
+
+	// Reject the new item with ErrFull
+	Reject
+)
+
+// Option is passed to the constructor of a queue to configure it
+type Option func(b *bounds)
+
+// WithCapacity limits the number of items in the queue to capacity. When the queue is full, the overflow policy is
+// applied to new items. A capacity of 0 means no limit.
+func WithCapacity(capacity int, overflow OverflowPolicy) Option {
+	return func(b *bounds) {
+		b.capacity = capacity
+		b.overflow = overflow
+	}
+}
+
+// bounds implements the capacity and overflow policy of a queue
+type bounds struct {
+	dropped  uint64 // sync/atomic aligned
+	capacity int
+	overflow OverflowPolicy
+	removed  chan struct{}
+	observer Observer // nil if the queue is not observed
+}
+
+func newBounds(opts []Option) bounds {
+	var b bounds
+	for _, opt := range opts {
+		opt(&b)
+	}
+	if b.capacity > 0 && b.overflow == Block {
+		b.removed = make(chan struct{})
+	}
+	return b
+}
+
+// makeRoom makes room for a new item according to the overflow policy. It must be called with mu locked, and it
+// unlocks mu while blocking. It returns ErrFull if the new item is rejected and errDropped if it is dropped.
+func (b *bounds) makeRoom(mu *sync.Mutex, overflow OverflowPolicy, size func() int, dropOldest func()) error {
+	for b.capacity > 0 && size() >= b.capacity {
+		switch overflow {
+		case Block:
+			removed := b.removed
+			mu.Unlock()
+			<-removed
+			mu.Lock()
+		case DropOldest:
+			dropOldest()
+			atomic.AddUint64(&b.dropped, 1)
+			b.observeDropped(size())
+		case DropNewest:
+			atomic.AddUint64(&b.dropped, 1)
+			b.observeDropped(size())
+			return errDropped
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+			b.observeDropped(size())
+			return ErrFull
+		}
+	}
+	return nil
+}
+
+// notifyRemoved wakes up producers that are blocked on a full queue. It must be called with the lock of the queue held.
+func (b *bounds) notifyRemoved() {
+	if b.removed == nil {
+		return
+	}
+	close(b.removed)
+	b.removed = make(chan struct{})
+}
+
+// Dropped returns the number of items that were dropped or rejected because the queue was full
+func (b *bounds) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
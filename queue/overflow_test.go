@@ -0,0 +1,156 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestSimpleOverflow(t *testing.T) {
+	a := New(t)
+
+	{
+		q := NewSimple(WithCapacity(2, DropOldest))
+		a.So(q.Add(1), ShouldBeNil)
+		a.So(q.Add(2), ShouldBeNil)
+		a.So(q.Add(3), ShouldBeNil)
+		a.So(q.Dropped(), ShouldEqual, 1)
+		a.So(q.Next(), ShouldEqual, 2)
+		a.So(q.Next(), ShouldEqual, 3)
+	}
+
+	{
+		q := NewSimple(WithCapacity(2, DropNewest))
+		a.So(q.Add(1), ShouldBeNil)
+		a.So(q.Add(2), ShouldBeNil)
+		a.So(q.Add(3), ShouldBeNil)
+		a.So(q.Dropped(), ShouldEqual, 1)
+		a.So(q.Next(), ShouldEqual, 1)
+		a.So(q.Next(), ShouldEqual, 2)
+		a.So(q.IsEmpty(), ShouldBeTrue)
+	}
+
+	{
+		q := NewSimple(WithCapacity(2, Reject))
+		a.So(q.Add(1), ShouldBeNil)
+		a.So(q.Add(2), ShouldBeNil)
+		a.So(q.Add(3), ShouldEqual, ErrFull)
+		a.So(q.Dropped(), ShouldEqual, 1)
+		a.So(q.Next(), ShouldEqual, 1)
+		a.So(q.Add(3), ShouldBeNil)
+	}
+
+	{
+		q := NewSimple(WithCapacity(1, Block))
+		a.So(q.Add(1), ShouldBeNil)
+
+		added := make(chan struct{})
+		go func() {
+			q.Add(2)
+			close(added)
+		}()
+
+		select {
+		case <-added:
+			t.Fatal("Add did not block on a full queue")
+		case <-time.After(sleepTime):
+		}
+
+		a.So(q.Next(), ShouldEqual, 1)
+		<-added
+		a.So(q.Next(), ShouldEqual, 2)
+		a.So(q.Dropped(), ShouldEqual, 0)
+	}
+
+	{
+		q := NewSimple(WithCapacity(1, Block))
+		q.Add(1)
+		added := make(chan struct{})
+		go func() {
+			q.Add(2)
+			close(added)
+		}()
+		time.Sleep(sleepTime)
+		q.Destroy()
+		<-added
+	}
+}
+
+func TestJITOverflow(t *testing.T) {
+	a := New(t)
+
+	now := time.Now()
+
+	{
+		q := NewJIT(WithCapacity(2, DropOldest))
+		a.So(errOf(q.Schedule(2, now.Add(-2*time.Millisecond))), ShouldBeNil)
+		a.So(errOf(q.Schedule(1, now.Add(-3*time.Millisecond))), ShouldBeNil)
+		a.So(errOf(q.Schedule(3, now.Add(-1*time.Millisecond))), ShouldBeNil)
+		a.So(q.Dropped(), ShouldEqual, 1)
+		a.So(q.Next(), ShouldEqual, 2)
+		a.So(q.Next(), ShouldEqual, 3)
+	}
+
+	{
+		q := NewJIT(WithCapacity(1, Reject))
+		a.So(errOf(q.Schedule(1, now)), ShouldBeNil)
+		a.So(errOf(q.Schedule(2, now)), ShouldEqual, ErrFull)
+		a.So(q.Dropped(), ShouldEqual, 1)
+	}
+}
+
+func TestScheduleOverflow(t *testing.T) {
+	a := New(t)
+
+	now := time.Now()
+
+	{
+		q := NewSchedule(WithCapacity(1, DropNewest))
+		q.Schedule(1, now.Add(-10*time.Millisecond), 5*time.Millisecond)
+		_, conflicts, err := q.Schedule(2, now.Add(-8*time.Millisecond), 5*time.Millisecond)
+		a.So(err, ShouldBeNil)
+		a.So(conflicts, ShouldHaveLength, 1)
+		a.So(q.Dropped(), ShouldEqual, 1)
+
+		_, t, err := q.ScheduleASAP(3, time.Millisecond)
+		a.So(err, ShouldBeNil)
+		a.So(t, ShouldResemble, time.Time{})
+		a.So(q.Dropped(), ShouldEqual, 2)
+
+		a.So(q.Next(), ShouldEqual, 1)
+		a.So(q.IsEmpty(), ShouldBeTrue)
+	}
+
+	{
+		q := NewSchedule(WithCapacity(1, Reject))
+		q.Schedule(1, now.Add(time.Hour), 5*time.Millisecond)
+		h, conflicts, err := q.Add(&scheduleItem{jitItem: jitItem{time: now.Add(time.Hour)}})
+		a.So(err, ShouldEqual, ErrFull)
+		a.So(conflicts, ShouldHaveLength, 1)
+		a.So(h.Cancel(), ShouldBeFalse)
+		_, _, err = q.ScheduleWithTimestamp(2, now, 1000, time.Millisecond)
+		a.So(err, ShouldEqual, ErrFull)
+		_, t, err := q.ScheduleASAP(3, time.Millisecond)
+		a.So(err, ShouldEqual, ErrFull)
+		a.So(t, ShouldResemble, time.Time{})
+		a.So(q.Dropped(), ShouldEqual, 3)
+
+		q.Destroy()
+		_, _, err = q.Schedule(4, now, time.Millisecond)
+		a.So(err, ShouldEqual, ErrDestroyed)
+	}
+
+	{
+		q := NewSchedule(WithCapacity(1, DropOldest))
+		q.Schedule(1, now.Add(-10*time.Millisecond), 5*time.Millisecond)
+		_, conflicts, err := q.Schedule(2, now.Add(-8*time.Millisecond), 5*time.Millisecond)
+		a.So(err, ShouldBeNil)
+		a.So(conflicts, ShouldBeEmpty)
+		a.So(q.Dropped(), ShouldEqual, 1)
+		a.So(q.Next(), ShouldEqual, 2)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority Queue implementation. Items with a higher priority are returned first, items with the same priority are
+// returned in the order in which they were added.
+type Priority interface {
+	Base
+
+	// Add an item with the given priority to the Queue
+	Add(i interface{}, priority int)
+}
+
+type priorityItem struct {
+	item     interface{}
+	priority int
+	added    time.Time
+	seq      uint64
+}
+
+type priorityHeap struct {
+	items []*priorityItem
+	aging time.Duration
+}
+
+func (h *priorityHeap) Len() int      { return len(h.items) }
+func (h *priorityHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *priorityHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.aging > 0 {
+		// The priority of an item increases by one for every aging duration it waits. As all items age at the same
+		// rate, the order of two items does not change over time.
+		aKey := int64(a.priority)*int64(h.aging) - a.added.UnixNano()
+		bKey := int64(b.priority)*int64(h.aging) - b.added.UnixNano()
+		if aKey != bKey {
+			return aKey > bKey
+		}
+	} else if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+func (h *priorityHeap) Push(x interface{}) { h.items = append(h.items, x.(*priorityItem)) }
+func (h *priorityHeap) Pop() interface{} {
+	last := len(h.items) - 1
+	i := h.items[last]
+	h.items[last] = nil
+	h.items = h.items[:last]
+	return i
+}
+
+type priorityQueue struct {
+	bounds // only used for the observer
+
+	mu      sync.Mutex
+	queue   *priorityHeap
+	seq     uint64
+	changed chan struct{}
+}
+
+// NewPriority returns a new Priority Queue. If aging is not zero, the priority of items increases by one for every
+// aging duration that they spend in the queue, so that items with a low priority are eventually returned. Priority
+// Queues are not bounded, so WithCapacity has no effect.
+func NewPriority(aging time.Duration, opts ...Option) Priority {
+	return &priorityQueue{
+		bounds:  newBounds(opts),
+		queue:   &priorityHeap{aging: aging},
+		changed: make(chan struct{}),
+	}
+}
+
+func (q *priorityQueue) Add(i interface{}, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil {
+		return
+	}
+	q.seq++
+	heap.Push(q.queue, &priorityItem{item: i, priority: priority, added: time.Now(), seq: q.seq})
+	q.observeEnqueued(q.queue.Len())
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *priorityQueue) Next() interface{} {
+	i, _ := q.NextContext(context.Background())
+	return i
+}
+
+func (q *priorityQueue) NextContext(ctx context.Context) (interface{}, error) {
+	for {
+		q.mu.Lock()
+		if q.changed == nil {
+			q.mu.Unlock()
+			return nil, ErrDestroyed
+		}
+		if !q.isEmpty() {
+			defer q.mu.Unlock()
+			return q.pop(), nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (q *priorityQueue) TryNext() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil || q.isEmpty() {
+		return nil, false
+	}
+	return q.pop(), true
+}
+
+// pop returns the first item, q.mu must be locked
+func (q *priorityQueue) pop() interface{} {
+	i := heap.Pop(q.queue).(*priorityItem)
+	q.observeDequeued(q.queue.Len(), i.added)
+	return i.item
+}
+
+func (q *priorityQueue) IsEmpty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.isEmpty()
+}
+
+func (q *priorityQueue) isEmpty() bool {
+	return q.queue.Len() == 0
+}
+
+func (q *priorityQueue) Destroy() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil {
+		return
+	}
+	q.queue.items = nil
+	close(q.changed)
+	q.changed = nil
+	q.observeDestroyed()
+}
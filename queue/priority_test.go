@@ -0,0 +1,72 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	a := New(t)
+	q := NewPriority(0)
+
+	a.So(q.IsEmpty(), ShouldBeTrue)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		a.So(q.Next(), ShouldEqual, "first")
+		wg.Done()
+	}()
+
+	time.Sleep(sleepTime)
+
+	q.Add("first", 0)
+
+	wg.Wait()
+
+	q.Add("low 1", 0)
+	q.Add("high 1", 10)
+	q.Add("low 2", 0)
+	q.Add("high 2", 10)
+	q.Add("medium", 5)
+
+	a.So(q.IsEmpty(), ShouldBeFalse)
+	a.So(q.Next(), ShouldEqual, "high 1")
+	a.So(q.Next(), ShouldEqual, "high 2")
+	a.So(q.Next(), ShouldEqual, "medium")
+	a.So(q.Next(), ShouldEqual, "low 1")
+	a.So(q.Next(), ShouldEqual, "low 2")
+
+	wg.Add(1)
+	go func() {
+		a.So(q.Next(), ShouldBeNil)
+		wg.Done()
+	}()
+
+	time.Sleep(sleepTime)
+
+	q.Destroy()
+
+	wg.Wait()
+}
+
+func TestPriorityQueueAging(t *testing.T) {
+	a := New(t)
+	q := NewPriority(sleepTime)
+
+	q.Add("low", 0)
+	time.Sleep(3 * sleepTime)
+	q.Add("high", 2)
+	q.Add("higher", 5)
+
+	a.So(q.Next(), ShouldEqual, "higher")
+	a.So(q.Next(), ShouldEqual, "low")
+	a.So(q.Next(), ShouldEqual, "high")
+}
@@ -0,0 +1,97 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+// Package prometheus exports metrics of queues to Prometheus
+package prometheus
+
+import (
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var queueDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ttn",
+		Subsystem: "queue",
+		Name:      "depth",
+		Help:      "Number of items in the queue.",
+	},
+	[]string{"queue"},
+)
+
+var queueDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ttn",
+		Subsystem: "queue",
+		Name:      "dropped_total",
+		Help:      "Total items that were dropped, rejected or canceled.",
+	},
+	[]string{"queue"},
+)
+
+var queueWait = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "ttn",
+		Subsystem: "queue",
+		Name:      "wait_seconds",
+		Help:      "Time that items waited in the queue.",
+		Buckets:   prometheus.DefBuckets,
+	},
+	[]string{"queue"},
+)
+
+var queueLateness = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "ttn",
+		Subsystem: "queue",
+		Name:      "lateness_seconds",
+		Help:      "Time between the time of items in JIT queues and schedules and the time at which they were returned.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	},
+	[]string{"queue"},
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, queueDropped, queueWait, queueLateness)
+}
+
+type observer struct {
+	depth    prometheus.Gauge
+	dropped  prometheus.Counter
+	wait     prometheus.Observer
+	lateness prometheus.Observer
+}
+
+func (o observer) Enqueued(depth int) {
+	o.depth.Set(float64(depth))
+}
+func (o observer) Dequeued(depth int, wait time.Duration) {
+	o.depth.Set(float64(depth))
+	o.wait.Observe(wait.Seconds())
+}
+func (o observer) Late(late time.Duration) {
+	o.lateness.Observe(late.Seconds())
+}
+func (o observer) Dropped(depth int) {
+	o.depth.Set(float64(depth))
+	o.dropped.Inc()
+}
+func (o observer) Destroyed() {
+	o.depth.Set(0)
+}
+
+// Observer returns a queue.Observer that exports the metrics of the queue with the given name
+//
+// Example:
+//
+//	q := queue.NewJIT(queue.WithObserver(prometheus.Observer("downlink")))
+func Observer(name string) queue.Observer {
+	return observer{
+		depth:    queueDepth.WithLabelValues(name),
+		dropped:  queueDropped.WithLabelValues(name),
+		wait:     queueWait.WithLabelValues(name),
+		lateness: queueLateness.WithLabelValues(name),
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package prometheus
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "github.com/smartystreets/assertions"
+)
+
+// runs makes the queue label unique, as the metrics are global and the tests may run more than once
+var runs uint64
+
+func write(m prometheus.Metric) *dto.Metric {
+	metric := new(dto.Metric)
+	m.Write(metric)
+	return metric
+}
+
+func TestPrometheus(t *testing.T) {
+	a := New(t)
+
+	name := fmt.Sprintf("test-%d", atomic.AddUint64(&runs, 1))
+	depth := func() float64 { return write(queueDepth.WithLabelValues(name)).GetGauge().GetValue() }
+
+	q := queue.NewJIT(queue.WithCapacity(2, queue.Reject), queue.WithObserver(Observer(name)))
+	now := time.Now()
+	q.Schedule("a", now.Add(-time.Second))
+	q.Schedule("b", now.Add(time.Hour))
+	q.Schedule("c", now.Add(time.Hour))
+
+	a.So(depth(), ShouldEqual, 2)
+	a.So(write(queueDropped.WithLabelValues(name)).GetCounter().GetValue(), ShouldEqual, 1)
+
+	a.So(q.Next(), ShouldEqual, "a")
+	a.So(depth(), ShouldEqual, 1)
+
+	wait := write(queueWait.WithLabelValues(name).(prometheus.Histogram)).GetHistogram()
+	a.So(wait.GetSampleCount(), ShouldEqual, 1)
+
+	lateness := write(queueLateness.WithLabelValues(name).(prometheus.Histogram)).GetHistogram()
+	a.So(lateness.GetSampleCount(), ShouldEqual, 1)
+	a.So(lateness.GetSampleSum(), ShouldBeGreaterThanOrEqualTo, 1)
+
+	// The items of a destroyed queue are discarded
+	q.Destroy()
+	a.So(depth(), ShouldEqual, 0)
+}
@@ -0,0 +1,354 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TheThingsNetwork/go-utils/log"
+	redis "gopkg.in/redis.v5"
+)
+
+// ErrVisibilityExpired is returned when a Delivery is acknowledged after its visibility timeout expired
+var ErrVisibilityExpired = errors.New("queue: visibility timeout expired")
+
+// RedisSettings for JIT Queues that are stored in Redis
+type RedisSettings struct {
+	// Codec serializes the items
+	Codec Codec
+
+	// VisibilityTimeout is the time after which an item that was returned by NextDelivery is delivered again if it is
+	// not acknowledged.
+	VisibilityTimeout time.Duration
+
+	// PollInterval is the maximum time between checks for new items that were added by other instances.
+	PollInterval time.Duration
+}
+
+// DefaultRedisSettings for JIT Queues that are stored in Redis
+var DefaultRedisSettings = RedisSettings{
+	Codec:             JSONCodec{},
+	VisibilityTimeout: 30 * time.Second,
+	PollInterval:      100 * time.Millisecond,
+}
+
+// RedisJIT is a JIT Queue that is stored in Redis, so that it can be shared by multiple instances. The time of items
+// is compared to the local clock, so the clocks of the instances should be synchronized.
+//
+// Items that are returned by Next(), NextContext() and TryNext() are removed from Redis immediately. Consumers that
+// need at-least-once delivery use NextDelivery() and acknowledge the Delivery when it is processed.
+//
+// Destroy stops local consumers, but keeps the items in Redis. The Queue is not bounded, so Dropped() is always 0.
+type RedisJIT interface {
+	JIT
+
+	// NextDelivery returns the next item without removing it from the Queue. If the Delivery is not acknowledged
+	// within the visibility timeout, the item is delivered again.
+	NextDelivery(ctx context.Context) (*Delivery, error)
+}
+
+// Delivery of an item by a RedisJIT
+type Delivery struct {
+	Item interface{}
+
+	q     *redisJIT
+	id    string
+	score float64
+}
+
+// Ack acknowledges that the item was processed, so that it is removed from the Queue. It returns ErrVisibilityExpired
+// if the visibility timeout expired, as the item may have been delivered again. The item then stays in the Queue.
+func (d *Delivery) Ack() error {
+	if time.Now().After(fromScore(d.score)) {
+		return ErrVisibilityExpired
+	}
+	return d.q.watch(func(tx *redis.Tx) error {
+		score, err := tx.ZScore(d.q.pendingKey, d.id).Result()
+		if err == redis.Nil || (err == nil && score != d.score) {
+			return ErrVisibilityExpired
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Pipelined(func(pipe *redis.Pipeline) error {
+			pipe.ZRem(d.q.pendingKey, d.id)
+			pipe.HDel(d.q.itemsKey, d.id)
+			return nil
+		})
+		return err
+	}, d.q.pendingKey)
+}
+
+// NewRedisJIT returns a new JIT Queue that stores its items in Redis under the given key
+func NewRedisJIT(client *redis.Client, key string, settings RedisSettings) RedisJIT {
+	if settings.Codec == nil {
+		settings.Codec = DefaultRedisSettings.Codec
+	}
+	if settings.VisibilityTimeout == 0 {
+		settings.VisibilityTimeout = DefaultRedisSettings.VisibilityTimeout
+	}
+	if settings.PollInterval == 0 {
+		settings.PollInterval = DefaultRedisSettings.PollInterval
+	}
+	return &redisJIT{
+		RedisSettings: settings,
+		client:        client,
+		pendingKey:    key + ":pending",
+		itemsKey:      key + ":items",
+		idKey:         key + ":id",
+		changed:       make(chan struct{}),
+	}
+}
+
+type redisJIT struct {
+	RedisSettings
+	client     *redis.Client
+	pendingKey string // sorted set of item IDs, scored by the time at which they are due
+	itemsKey   string // hash of item IDs to the marshaled items
+	idKey      string
+
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+// toScore converts a time to the score of an item. Scores are in microseconds, as scores are stored as float64.
+func toScore(t time.Time) float64 {
+	return float64(t.UnixNano() / 1000)
+}
+
+func fromScore(score float64) time.Time {
+	return time.Unix(0, int64(score)*1000)
+}
+
+// watch runs fn in a transaction that watches keys, and retries it if the keys were modified
+func (q *redisJIT) watch(fn func(*redis.Tx) error, keys ...string) error {
+	for {
+		err := q.client.Watch(fn, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+func (q *redisJIT) Add(i JITItem) (Handle, error) {
+	return q.add(i, i.Time())
+}
+
+func (q *redisJIT) Schedule(i interface{}, time time.Time) (Handle, error) {
+	return q.add(i, time)
+}
+
+func (q *redisJIT) add(i interface{}, t time.Time) (Handle, error) {
+	data, err := q.Codec.Marshal(i)
+	if err != nil {
+		return &redisHandle{q: q}, err
+	}
+	id, err := q.client.Incr(q.idKey).Result()
+	if err != nil {
+		return &redisHandle{q: q}, err
+	}
+	h := &redisHandle{q: q, id: strconv.FormatInt(id, 10)}
+	pipe := q.client.TxPipeline()
+	pipe.HSet(q.itemsKey, h.id, data)
+	pipe.ZAdd(q.pendingKey, redis.Z{Score: toScore(t), Member: h.id})
+	if _, err := pipe.Exec(); err != nil {
+		return &redisHandle{q: q}, err
+	}
+	q.notify()
+	return h, nil
+}
+
+// notify wakes up local consumers
+func (q *redisJIT) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil {
+		return
+	}
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *redisJIT) decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errCorrupt
+	}
+	return q.Codec.Unmarshal(data)
+}
+
+// claim claims the first item if it is due. If remove is true, the item is removed, otherwise it is hidden until the
+// visibility timeout expires. If no item is due, it returns the time until the first item is due, or 0 if the Queue is
+// empty.
+func (q *redisJIT) claim(remove bool) (d *Delivery, wait time.Duration, err error) {
+	err = q.watch(func(tx *redis.Tx) error {
+		d, wait = nil, 0
+		first, err := tx.ZRangeWithScores(q.pendingKey, 0, 0).Result()
+		if err != nil || len(first) == 0 {
+			return err
+		}
+		now := time.Now()
+		if at := fromScore(first[0].Score); at.After(now) {
+			wait = at.Sub(now)
+			return nil
+		}
+		id, _ := first[0].Member.(string)
+		data, err := tx.HGet(q.itemsKey, id).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		item, decodeErr := q.decode(data)
+		score := toScore(now.Add(q.VisibilityTimeout))
+		_, err = tx.Pipelined(func(pipe *redis.Pipeline) error {
+			if remove || decodeErr != nil {
+				pipe.ZRem(q.pendingKey, id)
+				pipe.HDel(q.itemsKey, id)
+			} else {
+				pipe.ZAdd(q.pendingKey, redis.Z{Score: score, Member: id})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if decodeErr != nil {
+			// The item can never be decoded, so it is removed instead of being delivered again and again
+			log.Get().WithError(decodeErr).WithField("id", id).Warn("queue: could not decode item, removing it")
+			return nil
+		}
+		d = &Delivery{Item: item, q: q, id: id, score: score}
+		return nil
+	}, q.pendingKey)
+	return
+}
+
+func (q *redisJIT) next(ctx context.Context, remove bool) (*Delivery, error) {
+	for {
+		q.mu.Lock()
+		changed := q.changed
+		q.mu.Unlock()
+		if changed == nil {
+			return nil, ErrDestroyed
+		}
+
+		d, wait, err := q.claim(remove)
+		if err != nil {
+			log.Get().WithError(err).Warn("queue: could not get next item from Redis")
+		}
+		if d != nil {
+			return d, nil
+		}
+		if wait == 0 || wait > q.PollInterval {
+			wait = q.PollInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (q *redisJIT) Next() interface{} {
+	i, _ := q.NextContext(context.Background())
+	return i
+}
+
+func (q *redisJIT) NextContext(ctx context.Context) (interface{}, error) {
+	d, err := q.next(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return d.Item, nil
+}
+
+func (q *redisJIT) NextDelivery(ctx context.Context) (*Delivery, error) {
+	return q.next(ctx, false)
+}
+
+func (q *redisJIT) TryNext() (interface{}, bool) {
+	q.mu.Lock()
+	destroyed := q.changed == nil
+	q.mu.Unlock()
+	if destroyed {
+		return nil, false
+	}
+	d, _, err := q.claim(true)
+	if err != nil || d == nil {
+		return nil, false
+	}
+	return d.Item, true
+}
+
+func (q *redisJIT) IsEmpty() bool {
+	n, _ := q.client.ZCard(q.pendingKey).Result()
+	return n == 0
+}
+
+func (q *redisJIT) Dropped() uint64 {
+	return 0
+}
+
+func (q *redisJIT) Destroy() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil {
+		return
+	}
+	close(q.changed)
+	q.changed = nil
+}
+
+type redisHandle struct {
+	q  *redisJIT
+	id string // empty if the item was never added
+}
+
+func (h *redisHandle) Cancel() bool {
+	if h.id == "" {
+		return false
+	}
+	pipe := h.q.client.TxPipeline()
+	removed := pipe.ZRem(h.q.pendingKey, h.id)
+	pipe.HDel(h.q.itemsKey, h.id)
+	if _, err := pipe.Exec(); err != nil {
+		return false
+	}
+	return removed.Val() == 1
+}
+
+func (h *redisHandle) Reschedule(t time.Time) bool {
+	if h.id == "" {
+		return false
+	}
+	var rescheduled bool
+	err := h.q.watch(func(tx *redis.Tx) error {
+		rescheduled = false
+		if err := tx.ZScore(h.q.pendingKey, h.id).Err(); err != nil {
+			if err == redis.Nil {
+				return nil
+			}
+			return err
+		}
+		_, err := tx.Pipelined(func(pipe *redis.Pipeline) error {
+			pipe.ZAdd(h.q.pendingKey, redis.Z{Score: toScore(t), Member: h.id})
+			return nil
+		})
+		rescheduled = err == nil
+		return err
+	}, h.q.pendingKey)
+	if err != nil {
+		return false
+	}
+	h.q.notify()
+	return rescheduled
+}
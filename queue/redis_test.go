@@ -0,0 +1,181 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+	redis "gopkg.in/redis.v5"
+)
+
+func getRedisClient() *redis.Client {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:6379", host),
+		Password: "",
+		DB:       2,
+	})
+}
+
+var redisKeys int
+
+// getRedisKey returns a new key for a RedisJIT, and a func that deletes it from Redis. As Next blocks while Redis is
+// unavailable, the test fails immediately if it can not connect.
+func getRedisKey(t *testing.T) (string, func()) {
+	if err := getRedisClient().Ping().Err(); err != nil {
+		t.Fatalf("Could not connect to Redis: %s", err)
+	}
+	redisKeys++
+	key := fmt.Sprintf("test-jit:%d", redisKeys)
+	return key, func() {
+		getRedisClient().Del(key+":pending", key+":items", key+":id")
+	}
+}
+
+func TestRedisJIT(t *testing.T) {
+	a := New(t)
+	key, cleanup := getRedisKey(t)
+	defer cleanup()
+
+	q := NewRedisJIT(getRedisClient(), key, DefaultRedisSettings)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+
+	now := time.Now()
+	q.Schedule("second", now.Add(-1*time.Millisecond))
+	q.Schedule("first", now.Add(-2*time.Millisecond))
+	later, _ := q.Schedule("later", now.Add(time.Hour))
+	canceled, _ := q.Schedule("canceled", now.Add(-3*time.Millisecond))
+
+	a.So(canceled.Cancel(), ShouldBeTrue)
+	a.So(canceled.Cancel(), ShouldBeFalse)
+	a.So(canceled.Reschedule(now), ShouldBeFalse)
+
+	a.So(q.Next(), ShouldEqual, "first")
+	i, ok := q.TryNext()
+	a.So(ok, ShouldBeTrue)
+	a.So(i, ShouldEqual, "second")
+	_, ok = q.TryNext()
+	a.So(ok, ShouldBeFalse)
+	a.So(q.IsEmpty(), ShouldBeFalse)
+
+	// Rescheduled items are returned at the new time
+	a.So(later.Reschedule(time.Now().Add(20*time.Millisecond)), ShouldBeTrue)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err := q.NextContext(ctx)
+	a.So(err, ShouldResemble, context.DeadlineExceeded)
+	a.So(q.Next(), ShouldEqual, "later")
+	a.So(q.IsEmpty(), ShouldBeTrue)
+
+	// Items that are added by other instances are returned
+	other := NewRedisJIT(getRedisClient(), key, DefaultRedisSettings)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		other.Schedule("other", time.Now())
+	}()
+	a.So(q.Next(), ShouldEqual, "other")
+
+	q.Destroy()
+	_, err = q.NextContext(context.Background())
+	a.So(err, ShouldEqual, ErrDestroyed)
+}
+
+func TestRedisJITWorkers(t *testing.T) {
+	a := New(t)
+	key, cleanup := getRedisKey(t)
+	defer cleanup()
+
+	const items = 50
+	q := NewRedisJIT(getRedisClient(), key, DefaultRedisSettings)
+	now := time.Now()
+	for i := 0; i < items; i++ {
+		q.Schedule(i, now.Add(time.Duration(i)*100*time.Microsecond))
+	}
+
+	var (
+		mu       sync.Mutex
+		received = make(map[float64]int)
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < 4; w++ {
+		worker := NewRedisJIT(getRedisClient(), key, DefaultRedisSettings)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !worker.IsEmpty() {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				i, err := worker.NextContext(ctx)
+				cancel()
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				received[i.(float64)]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	a.So(received, ShouldHaveLength, items)
+	for _, n := range received {
+		a.So(n, ShouldEqual, 1)
+	}
+}
+
+func TestRedisJITDelivery(t *testing.T) {
+	a := New(t)
+	key, cleanup := getRedisKey(t)
+	defer cleanup()
+
+	settings := DefaultRedisSettings
+	settings.VisibilityTimeout = 20 * time.Millisecond
+	settings.PollInterval = 5 * time.Millisecond
+	q := NewRedisJIT(getRedisClient(), key, settings)
+	other := NewRedisJIT(getRedisClient(), key, settings)
+
+	q.Schedule("item", time.Now())
+
+	d, err := q.NextDelivery(context.Background())
+	a.So(err, ShouldBeNil)
+	a.So(d.Item, ShouldEqual, "item")
+	a.So(q.IsEmpty(), ShouldBeFalse)
+
+	// The item is not delivered again before the visibility timeout expires
+	_, ok := other.TryNext()
+	a.So(ok, ShouldBeFalse)
+
+	// The item is delivered again when it was not acknowledged
+	start := time.Now()
+	redelivered, err := other.NextDelivery(context.Background())
+	a.So(err, ShouldBeNil)
+	a.So(redelivered.Item, ShouldEqual, "item")
+	a.So(time.Since(start), ShouldBeGreaterThan, 10*time.Millisecond)
+
+	a.So(d.Ack(), ShouldEqual, ErrVisibilityExpired)
+	a.So(redelivered.Ack(), ShouldBeNil)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	a.So(redelivered.Ack(), ShouldEqual, ErrVisibilityExpired)
+
+	// A late Ack fails, also if the item was not delivered again
+	q.Schedule("late", time.Now())
+	d, err = q.NextDelivery(context.Background())
+	a.So(err, ShouldBeNil)
+	time.Sleep(settings.VisibilityTimeout + 5*time.Millisecond)
+	a.So(d.Ack(), ShouldEqual, ErrVisibilityExpired)
+	a.So(q.IsEmpty(), ShouldBeFalse)
+	d, err = q.NextDelivery(context.Background())
+	a.So(err, ShouldBeNil)
+	a.So(d.Item, ShouldEqual, "late")
+	a.So(d.Ack(), ShouldBeNil)
+}
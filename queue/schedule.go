@@ -3,7 +3,12 @@
 
 package queue
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
 
 // Schedule is an extension of the JIT Queue that allows setting a duration next to the time of an item.
 // This allows to calculate the conflicts that an item has
@@ -12,7 +17,10 @@ type Schedule interface {
 
 	// Add an Item to the Schedule, queued to be returned at item.Time(),
 	// this func returns the conflicts based on item.Time() and item.Duration()
-	Add(item ScheduleItem) []ScheduleItem
+	// If the Schedule is full, the overflow policy is applied. It returns ErrFull if the item is rejected, and
+	// ErrDestroyed if the Schedule is destroyed.
+	// The returned Handle can be used to cancel or reschedule the item, which frees its slot in the Schedule.
+	Add(item ScheduleItem) (Handle, []ScheduleItem, error)
 
 	// Conflicts based on time and duration
 	Conflicts(time time.Time, duration time.Duration) []ScheduleItem
@@ -21,21 +29,70 @@ type Schedule interface {
 	ConflictsForTimestamp(timestamp int64, duration time.Duration) []ScheduleItem
 
 	// Schedule an item at the given time, with the given duration
-	// this func returns the conflicts based on item time and duration
-	Schedule(i interface{}, time time.Time, duration time.Duration) []ScheduleItem
+	// this func returns the conflicts based on item time and duration, and the same errors as Add
+	Schedule(i interface{}, time time.Time, duration time.Duration) (Handle, []ScheduleItem, error)
 
 	// Schedule an item at the given time+timestamp, with the given duration
-	// this func returns the conflicts based on item timestamp and duration
-	ScheduleWithTimestamp(i interface{}, time time.Time, timestamp int64, duration time.Duration) []ScheduleItem
+	// this func returns the conflicts based on item timestamp and duration, and the same errors as Add
+	ScheduleWithTimestamp(i interface{}, time time.Time, timestamp int64, duration time.Duration) (Handle, []ScheduleItem, error)
 
 	// ScheduleASAP schedules an item as soon as possible, given its duration and considering the existing Schedule
-	// this func returns the time at which the item is scheduled
-	ScheduleASAP(i interface{}, duration time.Duration) time.Time
+	// this func returns the time at which the item is scheduled, or the zero time if the item was not added, and the
+	// same errors as Add
+	ScheduleASAP(i interface{}, duration time.Duration) (Handle, time.Time, error)
+
+	// ScheduleWithin schedules an item in the first free slot that starts at or after earliest and ends at or before
+	// latest, given its duration and considering the existing Schedule. It returns the time at which the item is
+	// scheduled, or a *ConflictError with the items in the window if there is no free slot. If the Schedule is full,
+	// the overflow policy is applied. Like Add, it returns ErrFull if the item is rejected, and ErrDestroyed if the
+	// Schedule is destroyed.
+	ScheduleWithin(i interface{}, earliest, latest time.Time, duration time.Duration) (Handle, time.Time, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Schedule was full
+	Dropped() uint64
+}
+
+// ScheduleOf is the type-safe variant of Schedule, for items of type T
+type ScheduleOf[T any] interface {
+	BaseOf[T]
+
+	// Conflicts based on time and duration
+	Conflicts(time time.Time, duration time.Duration) []Scheduled[T]
+
+	// Conflicts based on timestamp and duration
+	ConflictsForTimestamp(timestamp int64, duration time.Duration) []Scheduled[T]
+
+	// Schedule an item at the given time, with the given duration (see Schedule)
+	Schedule(item T, time time.Time, duration time.Duration) (Handle, []Scheduled[T], error)
+
+	// Schedule an item at the given time+timestamp, with the given duration (see Schedule)
+	ScheduleWithTimestamp(item T, time time.Time, timestamp int64, duration time.Duration) (Handle, []Scheduled[T], error)
+
+	// ScheduleASAP schedules an item as soon as possible (see Schedule)
+	ScheduleASAP(item T, duration time.Duration) (Handle, time.Time, error)
+
+	// ScheduleWithin schedules an item in the first free slot within the window (see Schedule). It returns a
+	// *ConflictErrorOf[T] if there is no free slot.
+	ScheduleWithin(item T, earliest, latest time.Time, duration time.Duration) (Handle, time.Time, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Schedule was full
+	Dropped() uint64
+}
+
+// Scheduled is an item in a ScheduleOf
+type Scheduled[T any] struct {
+	Item     T
+	Time     time.Time
+	Duration time.Duration
+
+	// Timestamp is the timestamp of items that were scheduled with a timestamp, 0 otherwise
+	Timestamp int64
 }
 
 type scheduleItem struct {
 	jitItem
 	duration time.Duration
+	band     string // see BandItem
 }
 
 func (s scheduleItem) Duration() time.Duration {
@@ -63,6 +120,27 @@ type ScheduleItemWithTimestamp interface {
 	Duration() time.Duration
 }
 
+// ConflictError is returned when there is no free slot for an item
+type ConflictError struct {
+	Conflicts []ScheduleItem
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("queue: no free slot, %d conflicting items", len(e.Conflicts))
+}
+
+// ConflictErrorOf is the type-safe variant of ConflictError, returned by a ScheduleOf
+type ConflictErrorOf[T any] struct {
+	Conflicts []Scheduled[T]
+}
+
+func (e *ConflictErrorOf[T]) Error() string {
+	return fmt.Sprintf("queue: no free slot, %d conflicting items", len(e.Conflicts))
+}
+
+// errNoSlot is returned internally if there is no free slot, the Schedules return it with the conflicts in the window
+var errNoSlot = errors.New("queue: no free slot")
+
 // returns true if i before j
 func before(i, j ScheduleItem) bool {
 	iEnd := i.Time().UnixNano() + i.Duration().Nanoseconds()
@@ -77,6 +155,9 @@ func before(i, j ScheduleItem) bool {
 }
 
 func conflict(i, j ScheduleItem) bool {
+	if !shareResources(i, j) {
+		return false
+	}
 	if before(i, j) {
 		return false
 	}
@@ -86,91 +167,380 @@ func conflict(i, j ScheduleItem) bool {
 	return true
 }
 
-type schedule struct {
-	*jitQueue
-	last ScheduleItem
+// order compares the items of a Schedule. It is taken once per operation, so that a SyncedSchedule does not lock its
+// ClockSync for every comparison.
+type order struct {
+	clock *clockSnapshot // nil if timestamps are not converted to time
+}
+
+// order returns the order of the items at this moment
+func (q *schedule[T]) order() order {
+	if q.clock == nil {
+		return order{}
+	}
+	return order{clock: q.clock.snapshot()}
+}
+
+func (o order) before(i, j ScheduleItem) bool {
+	if o.clock != nil {
+		return o.clock.before(i, j)
+	}
+	return before(i, j)
+}
+
+// timeOf returns the time at which an item starts
+func (o order) timeOf(i ScheduleItem) time.Time {
+	if o.clock != nil {
+		return o.clock.timeOf(i)
+	}
+	return i.Time()
+}
+
+func (o order) conflict(i, j ScheduleItem) bool {
+	if o.clock == nil {
+		return conflict(i, j)
+	}
+	return shareResources(i, j) && !o.clock.before(i, j) && !o.clock.before(j, i)
+}
+
+type schedule[T any] struct {
+	*jitQueue[T]
+	last      map[string]*jitEntry[T] // by resource, "" for items that use all resources
+	index     scheduleIndex[T]        // of the queued items
+	dutyCycle *dutyCycle              // nil if the airtime is not limited
+	clock     *ClockSync              // nil if timestamps are not converted to time
 }
 
 // NewSchedule returns a new Schedule (see Schedule interface)
-func NewSchedule() Schedule {
-	return &schedule{
-		jitQueue: NewJIT().(*jitQueue),
+func NewSchedule(opts ...Option) Schedule {
+	return itemSchedule{newSchedule[interface{}](opts)}
+}
+
+// NewScheduleOf returns a new Schedule for items of type T
+func NewScheduleOf[T any](opts ...Option) ScheduleOf[T] {
+	return newSchedule[T](opts)
+}
+
+func newSchedule[T any](opts []Option) *schedule[T] {
+	q := &schedule[T]{
+		jitQueue: newJIT[T](opts),
+		last:     make(map[string]*jitEntry[T]),
+	}
+	q.jitQueue.onNext = q.setLast
+	q.jitQueue.onInsert = q.index.insert
+	q.jitQueue.onDelete = q.index.remove
+	return q
+}
+
+// setLast keeps track of the last item that was returned on each resource, as it may still conflict with new items
+func (q *schedule[T]) setLast(next *jitEntry[T]) {
+	item, ok := next.item.(ScheduleItem)
+	if !ok {
+		return
+	}
+	keys := resourcesOf(item)
+	if keys == nil {
+		keys = []string{""}
+	}
+	o := q.order()
+	for _, key := range keys {
+		if last, ok := q.last[key]; !ok || o.before(last.item.(ScheduleItem), item) {
+			q.last[key] = next
+		}
+	}
+}
+
+// conflicts returns the entries that conflict with i in chronological order, q.mu must be locked
+func (q *schedule[T]) conflicts(i ScheduleItem) []*jitEntry[T] {
+	return q.conflictsIn(q.order(), i)
+}
+
+// conflictsIn returns the entries that conflict with i in the given order, in chronological order. q.mu must be locked
+func (q *schedule[T]) conflictsIn(o order, i ScheduleItem) (conflicts []*jitEntry[T]) {
+	seen := make(map[*jitEntry[T]]bool)
+	check := func(e *jitEntry[T]) {
+		if seen[e] {
+			return
+		}
+		seen[e] = true
+		if o.conflict(i, e.item.(ScheduleItem)) {
+			conflicts = append(conflicts, e)
+		}
+	}
+	for _, last := range q.last {
+		check(last)
 	}
+	q.index.candidates(o, i, check)
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return o.timeOf(conflicts[i].item.(ScheduleItem)).Before(o.timeOf(conflicts[j].item.(ScheduleItem)))
+	})
+	return
 }
 
-func (q *schedule) Conflicts(time time.Time, duration time.Duration) []ScheduleItem {
+// scheduledOf returns the items of the entries as Scheduled items
+func scheduledOf[T any](entries []*jitEntry[T]) []Scheduled[T] {
+	if len(entries) == 0 {
+		return nil
+	}
+	res := make([]Scheduled[T], len(entries))
+	for i, e := range entries {
+		item := e.item.(ScheduleItem)
+		res[i] = Scheduled[T]{Item: e.value, Time: item.Time(), Duration: item.Duration()}
+		if item, ok := item.(ScheduleItemWithTimestamp); ok {
+			res[i].Timestamp = item.Timestamp()
+		}
+	}
+	return res
+}
+
+func (q *schedule[T]) Conflicts(time time.Time, duration time.Duration) []Scheduled[T] {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	return q.conflicts(&scheduleItem{jitItem: jitItem{time: time}, duration: duration})
+	return scheduledOf(q.conflicts(&scheduleItem{jitItem: jitItem{time: time}, duration: duration}))
 }
 
-func (q *schedule) ConflictsForTimestamp(timestamp int64, duration time.Duration) []ScheduleItem {
+func (q *schedule[T]) ConflictsForTimestamp(timestamp int64, duration time.Duration) []Scheduled[T] {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	return q.conflicts(&scheduleItemWithTimestamp{scheduleItem: scheduleItem{duration: duration}, timestamp: timestamp})
+	return scheduledOf(q.conflicts(&scheduleItemWithTimestamp{scheduleItem: scheduleItem{duration: duration}, timestamp: timestamp}))
 }
 
-func (q *schedule) conflicts(i ScheduleItem) (conflicts []ScheduleItem) {
-	queue := q.queue
-	if q.last != nil && conflict(i, q.last) {
-		conflicts = append(conflicts, q.last)
+// add returns the conflicts of i and adds it, to be returned as v, if there is room according to the overflow policy.
+// It is not added if it would exceed the duty cycle.
+func (q *schedule[T]) add(i ScheduleItem, v T) (h Handle, conflicts []*jitEntry[T], err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err = q.checkDutyCycle(i, nil); err != nil {
+		return q.handle(nil), q.conflicts(i), err
 	}
-	for _, qd := range queue {
-		if qd, ok := qd.(ScheduleItem); ok {
-			if conflict(i, qd) {
-				conflicts = append(conflicts, qd)
-			}
-		}
+	if q.changed == nil {
+		err = ErrDestroyed
+	} else if err = q.makeRoom(q.overflow); err == nil && q.changed == nil {
+		err = ErrDestroyed
+	}
+	conflicts = q.conflicts(i)
+	switch err {
+	case nil:
+		h = q.jitQueue.add(i, v)
+	case errDropped:
+		err = nil
+		fallthrough
+	default:
+		h = q.handle(nil)
 	}
 	return
 }
 
-func (q *schedule) Add(i ScheduleItem) (conflicts []ScheduleItem) {
+func (q *schedule[T]) Schedule(i T, time time.Time, duration time.Duration) (Handle, []Scheduled[T], error) {
+	h, conflicts, err := q.add(&scheduleItem{jitItem: jitItem{time: time}, duration: duration}, i)
+	return h, scheduledOf(conflicts), err
+}
+
+func (q *schedule[T]) ScheduleWithTimestamp(i T, time time.Time, timestamp int64, duration time.Duration) (Handle, []Scheduled[T], error) {
+	h, conflicts, err := q.add(&scheduleItemWithTimestamp{scheduleItem: scheduleItem{jitItem: jitItem{time: time}, duration: duration}, timestamp: timestamp}, i)
+	return h, scheduledOf(conflicts), err
+}
+
+func (q *schedule[T]) ScheduleASAP(i T, duration time.Duration) (Handle, time.Time, error) {
+	h, _, t, err := q.scheduleASAP(i, [][]string{nil}, "", duration)
+	return h, t, err
+}
+
+func (q *schedule[T]) ScheduleWithin(i T, earliest, latest time.Time, duration time.Duration) (Handle, time.Time, error) {
+	h, _, t, conflicts, err := q.scheduleWithin(i, [][]string{nil}, "", earliest, latest, duration)
+	if err == errNoSlot {
+		err = &ConflictErrorOf[T]{Conflicts: scheduledOf(conflicts)}
+	}
+	return h, t, err
+}
+
+// itemSchedule is a Schedule of interface{} items, to which ScheduleItems can also be added. It returns the conflicts
+// as the ScheduleItems that were added.
+type itemSchedule struct {
+	*schedule[interface{}]
+}
+
+// items returns the items of the entries as they were added, or as they were rescheduled
+func items(entries []*jitEntry[interface{}]) []ScheduleItem {
+	if len(entries) == 0 {
+		return nil
+	}
+	res := make([]ScheduleItem, len(entries))
+	for i, e := range entries {
+		res[i] = e.item.(ScheduleItem)
+	}
+	return res
+}
+
+// conflictError returns a *ConflictError if there is no free slot
+func conflictError(conflicts []*jitEntry[interface{}], err error) error {
+	if err == errNoSlot {
+		return &ConflictError{Conflicts: items(conflicts)}
+	}
+	return err
+}
+
+func (q itemSchedule) Conflicts(time time.Time, duration time.Duration) []ScheduleItem {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	conflicts = q.conflicts(i)
-	q.jitQueue.add(i)
-	return
+	return items(q.conflicts(&scheduleItem{jitItem: jitItem{time: time}, duration: duration}))
 }
 
-func (q *schedule) Schedule(i interface{}, time time.Time, duration time.Duration) []ScheduleItem {
-	return q.Add(&scheduleItem{jitItem: jitItem{item: i, time: time}, duration: duration})
+func (q itemSchedule) ConflictsForTimestamp(timestamp int64, duration time.Duration) []ScheduleItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return items(q.conflicts(&scheduleItemWithTimestamp{scheduleItem: scheduleItem{duration: duration}, timestamp: timestamp}))
 }
 
-func (q *schedule) ScheduleWithTimestamp(i interface{}, time time.Time, timestamp int64, duration time.Duration) []ScheduleItem {
-	return q.Add(&scheduleItemWithTimestamp{scheduleItem: scheduleItem{jitItem: jitItem{item: i, time: time}, duration: duration}, timestamp: timestamp})
+func (q itemSchedule) Add(i ScheduleItem) (Handle, []ScheduleItem, error) {
+	h, conflicts, err := q.add(i, i)
+	return h, items(conflicts), err
+}
+
+func (q itemSchedule) Schedule(i interface{}, time time.Time, duration time.Duration) (Handle, []ScheduleItem, error) {
+	h, conflicts, err := q.add(&scheduleItem{jitItem: jitItem{time: time}, duration: duration}, i)
+	return h, items(conflicts), err
+}
+
+func (q itemSchedule) ScheduleWithTimestamp(i interface{}, time time.Time, timestamp int64, duration time.Duration) (Handle, []ScheduleItem, error) {
+	h, conflicts, err := q.add(&scheduleItemWithTimestamp{scheduleItem: scheduleItem{jitItem: jitItem{time: time}, duration: duration}, timestamp: timestamp}, i)
+	return h, items(conflicts), err
+}
+
+func (q itemSchedule) ScheduleWithin(i interface{}, earliest, latest time.Time, duration time.Duration) (Handle, time.Time, error) {
+	h, _, t, conflicts, err := q.scheduleWithin(i, [][]string{nil}, "", earliest, latest, duration)
+	return h, t, conflictError(conflicts, err)
+}
+
+// newItem returns a new item in the given band that uses the given resources, or all resources if there are none
+func newItem(resources []string, band string, t time.Time, duration time.Duration) ScheduleItem {
+	item := scheduleItem{jitItem: jitItem{time: t}, duration: duration, band: band}
+	if len(resources) == 0 {
+		return &item
+	}
+	return &resourceItem{scheduleItem: item, resources: resources}
 }
 
-func (q *schedule) ScheduleASAP(i interface{}, duration time.Duration) time.Time {
+// scheduleASAP schedules an item in the band as soon as possible on the first of the options that has a free slot
+func (q *schedule[T]) scheduleASAP(i T, options [][]string, band string, duration time.Duration) (Handle, []string, time.Time, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	candidate := &scheduleItem{jitItem: jitItem{item: i, time: time.Now()}, duration: duration}
-	for _, qd := range q.queue {
-		if qd, ok := qd.(ScheduleItem); ok {
-			candidate.time = qd.Time().Add(qd.Duration() + 1)
-		} else {
-			continue
-		}
-		if len(q.conflicts(candidate)) == 0 {
-			break
+	if q.dutyCycle.exceeds(band, duration) {
+		return q.handle(nil), nil, time.Time{}, q.dutyCycle.exhausted(band)
+	}
+	if q.changed == nil {
+		return q.handle(nil), nil, time.Time{}, ErrDestroyed
+	}
+	if err := q.makeRoom(q.overflow); err != nil {
+		if err == errDropped {
+			err = nil
 		}
+		return q.handle(nil), nil, time.Time{}, err
+	}
+	if q.changed == nil {
+		// destroyed while blocked
+		return q.handle(nil), nil, time.Time{}, ErrDestroyed
 	}
-	q.add(candidate)
-	return candidate.time
+	resources, t, _, err := q.findSlot(options, time.Now(), time.Time{}, duration, q.available(band))
+	if err != nil {
+		return q.handle(nil), nil, time.Time{}, err
+	}
+	return q.jitQueue.add(newItem(resources, band, t, duration), i), resources, t, nil
 }
 
-func (q *schedule) Next() interface{} {
-	q.nextMu.Lock()
-	defer q.nextMu.Unlock()
-	next := q.jitQueue.next()
-	if next == nil {
-		return nil
+// scheduleWithin schedules an item in the band in the first free slot within the window on the first of the options
+// that has one. If there is no free slot, it returns errNoSlot and the conflicts in the window.
+func (q *schedule[T]) scheduleWithin(i T, options [][]string, band string, earliest, latest time.Time, duration time.Duration) (Handle, []string, time.Time, []*jitEntry[T], error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	noSlot := func() ([]*jitEntry[T], error) {
+		if _, _, ok, _ := q.findSlot(options, earliest, latest, duration, nil); ok {
+			// There is a free slot, but not enough airtime
+			return nil, q.dutyCycle.exhausted(band)
+		}
+		var all []string
+		for _, resources := range options {
+			if resources == nil {
+				all = nil
+				break
+			}
+			all = append(all, resources...)
+		}
+		return q.conflicts(newItem(all, band, earliest, latest.Sub(earliest))), errNoSlot
+	}
+	if q.changed == nil {
+		return q.handle(nil), nil, time.Time{}, nil, ErrDestroyed
+	}
+	if _, _, ok, err := q.findSlot(options, earliest, latest, duration, q.available(band)); err != nil || !ok {
+		var conflicts []*jitEntry[T]
+		if err == nil {
+			conflicts, err = noSlot()
+		}
+		return q.handle(nil), nil, time.Time{}, conflicts, err
+	}
+	if err := q.makeRoom(q.overflow); err != nil {
+		if err == errDropped {
+			err = nil
+		}
+		return q.handle(nil), nil, time.Time{}, nil, err
 	}
-	if next, ok := next.(ScheduleItem); ok && (q.last == nil || before(q.last, next)) {
-		q.last = next
+	if q.changed == nil {
+		// destroyed while blocked
+		return q.handle(nil), nil, time.Time{}, nil, ErrDestroyed
 	}
-	if next, ok := next.(hasItem); ok {
-		return next.getItem()
+	// The Schedule may have changed while making room
+	resources, t, ok, err := q.findSlot(options, earliest, latest, duration, q.available(band))
+	if err != nil || !ok {
+		var conflicts []*jitEntry[T]
+		if err == nil {
+			conflicts, err = noSlot()
+		}
+		return q.handle(nil), nil, time.Time{}, conflicts, err
+	}
+	return q.jitQueue.add(newItem(resources, band, t, duration), i), resources, t, nil, nil
+}
+
+// findSlot returns the first time at or after earliest at which an item does not conflict with the Schedule, and the
+// resources of the first option that has a slot at that time. If latest is not zero, the item must end at or before
+// latest. If available is not nil, it is used to delay the item until there is enough airtime. q.mu must be locked
+func (q *schedule[T]) findSlot(options [][]string, earliest, latest time.Time, duration time.Duration, available func(time.Time, time.Duration) (time.Time, error)) (resources []string, t time.Time, ok bool, err error) {
+	o := q.order()
+	for _, option := range options {
+		at := q.free(o, option, earliest, duration)
+		for available != nil && (latest.IsZero() || !at.Add(duration).After(latest)) {
+			next, err := available(at, duration)
+			if err != nil {
+				return nil, time.Time{}, false, err
+			}
+			if !next.After(at) {
+				break
+			}
+			at = q.free(o, option, next, duration)
+		}
+		if !latest.IsZero() && at.Add(duration).After(latest) {
+			continue
+		}
+		if !ok || at.Before(t) {
+			resources, t, ok = option, at, true
+		}
+	}
+	return
+}
+
+// free returns the first time at or after at at which an item on the resources does not conflict with the Schedule.
+// q.mu must be locked
+func (q *schedule[T]) free(o order, resources []string, at time.Time, duration time.Duration) time.Time {
+	for {
+		conflicts := q.conflictsIn(o, newItem(resources, "", at, duration))
+		if len(conflicts) == 0 {
+			return at
+		}
+		// The item can not start before the end of any of the conflicts
+		for _, e := range conflicts {
+			item := e.item.(ScheduleItem)
+			if end := o.timeOf(item).Add(item.Duration() + 1); end.After(at) {
+				at = end
+			}
+		}
 	}
-	return next
 }
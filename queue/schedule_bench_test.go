@@ -0,0 +1,127 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// sortedSchedule is the previous implementation of the Schedule storage, which is kept for comparison in the benchmarks
+type sortedSchedule struct {
+	sortedJIT
+}
+
+func (s *sortedSchedule) conflicts(i ScheduleItem) (conflicts []ScheduleItem) {
+	for _, qd := range s.sortedJIT {
+		if qd, ok := qd.(ScheduleItem); ok && conflict(i, qd) {
+			conflicts = append(conflicts, qd)
+		}
+	}
+	return
+}
+
+func (s *sortedSchedule) add(i ScheduleItem) []ScheduleItem {
+	conflicts := s.conflicts(i)
+	s.sortedJIT.add(i)
+	return conflicts
+}
+
+func (s *sortedSchedule) scheduleASAP(duration time.Duration) time.Time {
+	candidate := &scheduleItem{jitItem: jitItem{time: time.Now()}, duration: duration}
+	for _, qd := range s.sortedJIT {
+		if qd, ok := qd.(ScheduleItem); ok {
+			candidate.time = qd.Time().Add(qd.Duration() + 1)
+		} else {
+			continue
+		}
+		if len(s.conflicts(candidate)) == 0 {
+			break
+		}
+	}
+	s.sortedJIT.add(candidate)
+	return candidate.time
+}
+
+type scheduleStorage interface {
+	add(i ScheduleItem) []ScheduleItem
+	scheduleASAP(duration time.Duration) time.Time
+	pop() JITItem
+}
+
+type heapSchedule struct{ q itemSchedule }
+
+func (h heapSchedule) add(i ScheduleItem) []ScheduleItem {
+	_, conflicts, _ := h.q.Add(i)
+	return conflicts
+}
+
+func (h heapSchedule) scheduleASAP(duration time.Duration) time.Time {
+	_, t, _ := h.q.ScheduleASAP(nil, duration)
+	return t
+}
+
+func (h heapSchedule) pop() JITItem {
+	h.q.mu.Lock()
+	defer h.q.mu.Unlock()
+	return h.q.pop().item
+}
+
+const benchmarkDuration = 100 * time.Millisecond
+
+// scheduleBenchmarkSizes are smaller than the jitBenchmarkSizes, as filling a Schedule takes longer
+var scheduleBenchmarkSizes = []int{100, 1000, 3000}
+
+func benchmarkScheduleItems(n int) []ScheduleItem {
+	now := time.Now()
+	r := rand.New(rand.NewSource(42))
+	items := make([]ScheduleItem, n)
+	for i := range items {
+		items[i] = &scheduleItem{
+			jitItem:  jitItem{time: now.Add(time.Duration(r.Int63n(int64(time.Hour))))},
+			duration: benchmarkDuration,
+		}
+	}
+	return items
+}
+
+func benchmarkSchedule(b *testing.B, newStorage func() scheduleStorage) {
+	for _, size := range scheduleBenchmarkSizes {
+		size := size
+		fill := func() scheduleStorage {
+			s := newStorage()
+			for _, i := range benchmarkScheduleItems(size) {
+				s.add(i)
+			}
+			return s
+		}
+		b.Run(fmt.Sprintf("AddPop/%d", size), func(b *testing.B) {
+			s := fill()
+			items := benchmarkScheduleItems(b.N)
+			b.ResetTimer()
+			for _, i := range items {
+				s.add(i)
+				s.pop()
+			}
+		})
+		b.Run(fmt.Sprintf("ScheduleASAPPop/%d", size), func(b *testing.B) {
+			s := fill()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.scheduleASAP(benchmarkDuration)
+				s.pop()
+			}
+		})
+	}
+}
+
+func BenchmarkScheduleHeap(b *testing.B) {
+	benchmarkSchedule(b, func() scheduleStorage { return heapSchedule{itemSchedule{newSchedule[interface{}](nil)}} })
+}
+
+func BenchmarkScheduleSorted(b *testing.B) {
+	benchmarkSchedule(b, func() scheduleStorage { return new(sortedSchedule) })
+}
@@ -48,21 +48,21 @@ func TestSchedulingConflicts(t *testing.T) {
 
 	{
 		q := NewSchedule()
-		a.So(q.Schedule("0", base.time, base.duration), ShouldHaveLength, 0)
+		a.So(conflictsOf(q.Schedule("0", base.time, base.duration)), ShouldHaveLength, 0)
 		q.Next()
 		a.So(q.Conflicts(base.time.Add(base.duration+1), base.duration), ShouldHaveLength, 0)
-		a.So(q.Schedule("1", base.time.Add(base.duration+1), base.duration), ShouldHaveLength, 0)
+		a.So(conflictsOf(q.Schedule("1", base.time.Add(base.duration+1), base.duration)), ShouldHaveLength, 0)
 		a.So(q.Conflicts(base.time.Add(base.duration-1), base.duration), ShouldHaveLength, 2)
-		a.So(q.Schedule("2", base.time.Add(base.duration-1), base.duration), ShouldHaveLength, 2)
+		a.So(conflictsOf(q.Schedule("2", base.time.Add(base.duration-1), base.duration)), ShouldHaveLength, 2)
 	}
 
 	{
 		q := NewSchedule()
-		a.So(q.ScheduleWithTimestamp("0", base.time, 0, 10), ShouldHaveLength, 0)
+		a.So(conflictsOf(q.ScheduleWithTimestamp("0", base.time, 0, 10)), ShouldHaveLength, 0)
 		a.So(q.ConflictsForTimestamp(11, 10), ShouldHaveLength, 0)
-		a.So(q.ScheduleWithTimestamp("1", base.time, 11, 10), ShouldHaveLength, 0)
+		a.So(conflictsOf(q.ScheduleWithTimestamp("1", base.time, 11, 10)), ShouldHaveLength, 0)
 		a.So(q.ConflictsForTimestamp(9, 10), ShouldHaveLength, 2)
-		a.So(q.ScheduleWithTimestamp("2", base.time, 9, 10), ShouldHaveLength, 2)
+		a.So(conflictsOf(q.ScheduleWithTimestamp("2", base.time, 9, 10)), ShouldHaveLength, 2)
 	}
 
 }
@@ -127,18 +127,139 @@ func TestScheduleASAP(t *testing.T) {
 
 	{
 		q := NewSchedule()
-		a.So(q.ScheduleASAP("1", block), ShouldHappenWithin, time.Millisecond, time.Now())
-		a.So(q.ScheduleASAP("2", block), ShouldHappenWithin, time.Millisecond, time.Now().Add(block))
+		a.So(timeOf(q.ScheduleASAP("1", block)), ShouldHappenWithin, time.Millisecond, time.Now())
+		a.So(timeOf(q.ScheduleASAP("2", block)), ShouldHappenWithin, time.Millisecond, time.Now().Add(block))
 	}
 
 	{
 		q := NewSchedule()
-		q.Schedule("0", time.Now().Add(-1*block), block*2)                                                    // from -1 to 1 block
-		q.Next()                                                                                              // removed from schedule, but still a conflict
-		q.Schedule("1", time.Now(), block)                                                                    // from 0 to 1 block
-		q.Schedule("2", time.Now().Add(block/2), block)                                                       // from 0.5 to 1.5 block
-		q.Schedule("4", time.Now().Add(block*3), block)                                                       // from 3 to 4 block
-		a.So(q.ScheduleASAP("3", block), ShouldHappenWithin, time.Millisecond, time.Now().Add(block/2+block)) // from 1.5 to 2.5 block
+		q.Schedule("0", time.Now().Add(-1*block), block*2)                                                            // from -1 to 1 block
+		q.Next()                                                                                                      // removed from schedule, but still a conflict
+		q.Schedule("1", time.Now(), block)                                                                            // from 0 to 1 block
+		q.Schedule("2", time.Now().Add(block/2), block)                                                               // from 0.5 to 1.5 block
+		q.Schedule("4", time.Now().Add(block*3), block)                                                               // from 3 to 4 block
+		a.So(timeOf(q.ScheduleASAP("3", block)), ShouldHappenWithin, time.Millisecond, time.Now().Add(block/2+block)) // from 1.5 to 2.5 block
 	}
 
+	{
+		q := NewSchedule()
+		q.Schedule("1", time.Now().Add(2*block), block)                                                         // from 2 to 3 block
+		a.So(timeOf(q.ScheduleASAP("0", block)), ShouldHappenWithin, time.Millisecond, time.Now())              // gap before the first item
+		a.So(timeOf(q.ScheduleASAP("2", block/2)), ShouldHappenWithin, time.Millisecond, time.Now().Add(block)) // gap between the items
+	}
+
+	{
+		q := NewSchedule()
+		q.Destroy()
+		h, t0, err := q.ScheduleASAP("0", block)
+		a.So(err, ShouldEqual, ErrDestroyed)
+		a.So(t0.IsZero(), ShouldBeTrue)
+		a.So(h.Cancel(), ShouldBeFalse)
+	}
+}
+
+func TestScheduleWithin(t *testing.T) {
+	block := 2 * time.Second
+
+	a := New(t)
+
+	now := time.Now().Add(time.Hour)
+	at := func(blocks float64) time.Time { return now.Add(time.Duration(blocks * float64(block))) }
+
+	q := NewSchedule()
+	q.Schedule("1", at(1), block) // from 1 to 2 block
+	q.Schedule("3", at(3), block) // from 3 to 4 block
+
+	// Fits in the window before the first item
+	_, t0, err := q.ScheduleWithin("0", at(0), at(4), block/2)
+	a.So(err, ShouldBeNil)
+	a.So(t0, ShouldResemble, at(0))
+
+	// Does not fit before the first item anymore, so it goes in the gap between the items
+	_, t2, err := q.ScheduleWithin("2", at(0), at(4), block/2)
+	a.So(err, ShouldBeNil)
+	a.So(t2, ShouldHappenWithin, time.Microsecond, at(2))
+
+	// Not before earliest
+	_, t4, err := q.ScheduleWithin("4", at(4.5), at(10), block)
+	a.So(err, ShouldBeNil)
+	a.So(t4, ShouldResemble, at(4.5))
+
+	// No free slot before latest
+	h, t5, err := q.ScheduleWithin("5", at(0), at(4), block/2)
+	a.So(h.Cancel(), ShouldBeFalse)
+	a.So(t5.IsZero(), ShouldBeTrue)
+	a.So(err, ShouldHaveSameTypeAs, &ConflictError{})
+	a.So(err.(*ConflictError).Conflicts, ShouldHaveLength, 4)
+
+	// A window that is too small
+	_, _, err = q.ScheduleWithin("6", at(10), at(10.5), block)
+	a.So(err, ShouldHaveSameTypeAs, &ConflictError{})
+	a.So(err.(*ConflictError).Conflicts, ShouldBeEmpty)
+
+	{
+		q := NewSchedule(WithCapacity(1, Reject))
+		q.Schedule("1", at(1), block)
+		_, _, err := q.ScheduleWithin("2", at(2), at(4), block)
+		a.So(err, ShouldEqual, ErrFull)
+	}
+
+	{
+		q := NewSchedule()
+		q.Destroy()
+		_, t0, err := q.ScheduleWithin("0", at(0), at(4), block)
+		a.So(err, ShouldEqual, ErrDestroyed)
+		a.So(t0.IsZero(), ShouldBeTrue)
+	}
+}
+
+func TestScheduleOf(t *testing.T) {
+	a := New(t)
+
+	q := NewScheduleOf[*message]()
+	now := time.Now().Add(time.Hour)
+
+	_, conflicts, err := q.Schedule(&message{ID: 1}, now, 10*time.Millisecond)
+	a.So(err, ShouldBeNil)
+	a.So(conflicts, ShouldBeEmpty)
+	h, conflicts, err := q.Schedule(&message{ID: 2}, now.Add(5*time.Millisecond), 10*time.Millisecond)
+	a.So(err, ShouldBeNil)
+	a.So(conflicts, ShouldHaveLength, 1)
+	a.So(conflicts[0].Item.ID, ShouldEqual, 1)
+	a.So(conflicts[0].Time, ShouldEqual, now)
+	a.So(conflicts[0].Duration, ShouldEqual, 10*time.Millisecond)
+
+	// Rescheduled items are returned with their new time
+	a.So(h.Reschedule(now.Add(time.Second)), ShouldBeTrue)
+	conflicts = q.Conflicts(now.Add(time.Second), time.Millisecond)
+	a.So(conflicts, ShouldHaveLength, 1)
+	a.So(conflicts[0].Item.ID, ShouldEqual, 2)
+	a.So(conflicts[0].Time, ShouldEqual, now.Add(time.Second))
+
+	_, conflicts, err = q.ScheduleWithTimestamp(&message{ID: 3}, now.Add(time.Minute), 1000, 10)
+	a.So(err, ShouldBeNil)
+	a.So(conflicts, ShouldBeEmpty)
+	conflicts = q.ConflictsForTimestamp(1005, 10)
+	a.So(conflicts, ShouldHaveLength, 1)
+	a.So(conflicts[0].Item.ID, ShouldEqual, 3)
+	a.So(conflicts[0].Timestamp, ShouldEqual, 1000)
+
+	_, _, err = q.ScheduleWithin(&message{ID: 4}, now, now.Add(15*time.Millisecond), 10*time.Millisecond)
+	a.So(err, ShouldHaveSameTypeAs, &ConflictErrorOf[*message]{})
+	a.So(err.(*ConflictErrorOf[*message]).Conflicts[0].Item.ID, ShouldEqual, 1)
+
+	_, at, err := q.ScheduleASAP(&message{ID: 5}, time.Millisecond)
+	a.So(err, ShouldBeNil)
+	a.So(at, ShouldHappenBefore, now)
+	a.So(q.Next().ID, ShouldEqual, 5)
+
+	// Items that were returned still conflict with new items
+	r := NewScheduleOf[int](WithCapacity(1, Reject))
+	r.Schedule(42, time.Now(), time.Hour)
+	_, _, err = r.Schedule(43, time.Now(), time.Millisecond)
+	a.So(err, ShouldEqual, ErrFull)
+	a.So(r.Next(), ShouldEqual, 42)
+	returned := r.Conflicts(time.Now(), time.Millisecond)
+	a.So(returned, ShouldHaveLength, 1)
+	a.So(returned[0].Item, ShouldEqual, 42)
 }
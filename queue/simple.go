@@ -3,69 +3,230 @@
 
 package queue
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 // Simple Queue implementation
 type Simple interface {
 	Base
 
-	// Add an item to the Queue
-	Add(interface{})
+	// Add an item to the Queue. If the Queue is full, the overflow policy is applied.
+	// It returns ErrFull if the item is rejected, and ErrDestroyed if the Queue is destroyed.
+	Add(interface{}) error
+
+	// NextBatch returns up to max items. It blocks until an item is available, and then waits up to maxWait for more
+	// items, unless max items are available. It returns <nil> to all callers when Destroy() is called.
+	NextBatch(max int, maxWait time.Duration) []interface{}
+
+	// NextBatchContext is like NextBatch, but it also returns when the context is done. It returns the context error if
+	// the context is done before an item is available, and ErrDestroyed when Destroy() is called. If the context is done
+	// while it waits for more items, it returns the items that are already removed from the Queue.
+	NextBatchContext(ctx context.Context, max int, maxWait time.Duration) ([]interface{}, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Queue was full
+	Dropped() uint64
 }
 
-type simpleQueue struct {
-	mu        sync.Mutex
-	queue     []interface{}
-	available *sync.Cond
+// SimpleOf is the type-safe variant of Simple, for items of type T
+type SimpleOf[T any] interface {
+	BaseOf[T]
+
+	// Add an item to the Queue. If the Queue is full, the overflow policy is applied.
+	// It returns ErrFull if the item is rejected, and ErrDestroyed if the Queue is destroyed.
+	Add(item T) error
+
+	// NextBatch returns up to max items (see Simple). It returns <nil> to all callers when Destroy() is called.
+	NextBatch(max int, maxWait time.Duration) []T
+
+	// NextBatchContext is like NextBatch, but it also returns when the context is done (see Simple).
+	NextBatchContext(ctx context.Context, max int, maxWait time.Duration) ([]T, error)
+
+	// Dropped returns the number of items that were dropped or rejected because the Queue was full
+	Dropped() uint64
+}
+
+type simpleQueue[T any] struct {
+	bounds
+
+	mu      sync.Mutex
+	queue   []simpleEntry[T]
+	changed chan struct{}
+
+	// onAdd is called (while locked) before an item is added, the item is not added if it returns an error
+	onAdd func(T) error
+
+	// onRemove is called (while locked) for every item that is returned or dropped
+	onRemove func(T)
+}
+
+type simpleEntry[T any] struct {
+	item  T
+	added time.Time
 }
 
 // NewSimple returns a new Simple Queue
-func NewSimple() Simple {
-	q := &simpleQueue{
-		queue: make([]interface{}, 0),
+func NewSimple(opts ...Option) Simple {
+	return newSimple[interface{}](opts)
+}
+
+// NewSimpleOf returns a new Simple Queue for items of type T
+func NewSimpleOf[T any](opts ...Option) SimpleOf[T] {
+	return newSimple[T](opts)
+}
+
+func newSimple[T any](opts []Option) *simpleQueue[T] {
+	return &simpleQueue[T]{
+		bounds:  newBounds(opts),
+		queue:   make([]simpleEntry[T], 0),
+		changed: make(chan struct{}),
 	}
-	q.available = sync.NewCond(&q.mu)
-	return q
 }
 
-func (q *simpleQueue) Add(i interface{}) {
+func (q *simpleQueue[T]) Add(i T) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	wasEmpty := q.isEmpty()
-	q.queue = append(q.queue, i)
-	if wasEmpty {
-		q.available.Signal()
+	if q.changed == nil {
+		return ErrDestroyed
+	}
+	if err := q.makeRoom(&q.mu, q.overflow, q.len, func() { q.next() }); err != nil {
+		if err == errDropped {
+			return nil
+		}
+		return err
+	}
+	if q.changed == nil {
+		return ErrDestroyed
+	}
+	if q.onAdd != nil {
+		if err := q.onAdd(i); err != nil {
+			return err
+		}
 	}
+	q.queue = append(q.queue, simpleEntry[T]{item: i, added: time.Now()})
+	q.observeEnqueued(len(q.queue))
+	close(q.changed)
+	q.changed = make(chan struct{})
+	return nil
 }
 
-func (q *simpleQueue) Next() interface{} {
+func (q *simpleQueue[T]) Next() T {
+	i, _ := q.NextContext(context.Background())
+	return i
+}
+
+func (q *simpleQueue[T]) NextContext(ctx context.Context) (T, error) {
+	var zero T
+	for {
+		q.mu.Lock()
+		if q.changed == nil {
+			q.mu.Unlock()
+			return zero, ErrDestroyed
+		}
+		if !q.isEmpty() {
+			defer q.mu.Unlock()
+			return q.dequeue(), nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (q *simpleQueue[T]) TryNext() (T, bool) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	if q.isEmpty() {
-		q.available.Wait()
+	if q.changed == nil || q.isEmpty() {
+		var zero T
+		return zero, false
 	}
-	if q.isEmpty() {
-		return nil
+	return q.dequeue(), true
+}
+
+func (q *simpleQueue[T]) NextBatch(max int, maxWait time.Duration) []T {
+	batch, _ := q.NextBatchContext(context.Background(), max, maxWait)
+	return batch
+}
+
+func (q *simpleQueue[T]) NextBatchContext(ctx context.Context, max int, maxWait time.Duration) ([]T, error) {
+	first, err := q.NextContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	i := q.queue[0]
+	batch := []T{first}
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	for len(batch) < max {
+		q.mu.Lock()
+		for len(batch) < max && q.changed != nil && !q.isEmpty() {
+			batch = append(batch, q.dequeue())
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		if changed == nil || len(batch) == max {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return batch, nil
+		case <-timer.C:
+			return batch, nil
+		case <-changed:
+		}
+	}
+	return batch, nil
+}
+
+// dequeue returns the first item, q.mu must be locked
+func (q *simpleQueue[T]) dequeue() T {
+	e := q.next()
+	q.observeDequeued(len(q.queue), e.added)
+	return e.item
+}
+
+// next removes the first item, q.mu must be locked
+func (q *simpleQueue[T]) next() simpleEntry[T] {
+	e := q.queue[0]
+	q.queue[0] = simpleEntry[T]{}
 	q.queue = q.queue[1:]
+	q.notifyRemoved()
+	if q.onRemove != nil {
+		q.onRemove(e.item)
+	}
+	return e
+}
 
-	return i
+func (q *simpleQueue[T]) len() int {
+	return len(q.queue)
 }
 
-func (q *simpleQueue) IsEmpty() bool {
+func (q *simpleQueue[T]) IsEmpty() bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	return q.isEmpty()
 }
 
-func (q *simpleQueue) isEmpty() bool {
+func (q *simpleQueue[T]) isEmpty() bool {
 	return len(q.queue) == 0
 }
 
-func (q *simpleQueue) Destroy() {
+func (q *simpleQueue[T]) Destroy() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.queue = make([]interface{}, 0)
-	q.available.Broadcast()
+	if q.changed == nil {
+		return
+	}
+	q.queue = make([]simpleEntry[T], 0)
+	close(q.changed)
+	q.changed = nil
+	q.notifyRemoved()
+	q.observeDestroyed()
 }
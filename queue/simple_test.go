@@ -4,6 +4,7 @@
 package queue
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -54,3 +55,41 @@ func TestSimpleQueue(t *testing.T) {
 	wg.Wait()
 
 }
+
+type message struct {
+	ID int
+}
+
+func TestSimpleOf(t *testing.T) {
+	a := New(t)
+
+	q := NewSimpleOf[*message](WithCapacity(2, Reject))
+	a.So(q.Add(&message{ID: 1}), ShouldBeNil)
+	a.So(q.Add(&message{ID: 2}), ShouldBeNil)
+	a.So(q.Add(&message{ID: 3}), ShouldEqual, ErrFull)
+	a.So(q.Dropped(), ShouldEqual, 1)
+	a.So(q.Next().ID, ShouldEqual, 1)
+	m, ok := q.TryNext()
+	a.So(ok, ShouldBeTrue)
+	a.So(m.ID, ShouldEqual, 2)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	m, err := q.NextContext(ctx)
+	a.So(err, ShouldResemble, context.DeadlineExceeded)
+	a.So(m, ShouldBeNil)
+
+	// Values are returned as the zero value when the Queue is destroyed
+	i := NewSimpleOf[int]()
+	go i.Destroy()
+	a.So(i.Next(), ShouldEqual, 0)
+
+	// Batches are typed
+	i = NewSimpleOf[int]()
+	i.Add(1)
+	i.Add(2)
+	a.So(i.NextBatch(3, time.Millisecond), ShouldResemble, []int{1, 2})
+	go i.Destroy()
+	a.So(i.NextBatch(3, time.Millisecond), ShouldBeNil)
+}
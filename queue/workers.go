@@ -0,0 +1,57 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Workers consume the items of a Simple Queue in batches
+type Workers struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartWorkers starts n workers that call process with batches of up to max items from the Queue (see NextBatch).
+// The workers stop when Stop() is called and the Queue is drained, or when the Queue is destroyed.
+func StartWorkers(q Simple, n, max int, maxWait time.Duration, process func(batch []interface{})) *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Workers{cancel: cancel, done: make(chan struct{})}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				// Once stopped, the workers get the items that are available until the Queue is empty
+				batch, err := q.NextBatchContext(ctx, max, maxWait)
+				if len(batch) > 0 {
+					process(batch)
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(w.done)
+	}()
+	return w
+}
+
+// Stop stops the workers after they processed the items that are left in the Queue. It returns the context error if
+// the context is done before the workers stopped.
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
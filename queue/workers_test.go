@@ -0,0 +1,98 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestNextBatch(t *testing.T) {
+	a := New(t)
+
+	q := NewSimple()
+	for i := 0; i < 5; i++ {
+		q.Add(i)
+	}
+
+	// Available items are returned without waiting
+	start := time.Now()
+	a.So(q.NextBatch(3, time.Hour), ShouldResemble, []interface{}{0, 1, 2})
+	a.So(time.Since(start), ShouldBeLessThan, 10*time.Millisecond)
+
+	// It waits for more items
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		q.Add(5)
+	}()
+	a.So(q.NextBatch(3, time.Hour), ShouldResemble, []interface{}{3, 4, 5})
+
+	// Until maxWait
+	q.Add(6)
+	start = time.Now()
+	a.So(q.NextBatch(3, 10*time.Millisecond), ShouldResemble, []interface{}{6})
+	a.So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+
+	// Until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	batch, err := q.NextBatchContext(ctx, 3, time.Hour)
+	a.So(err, ShouldResemble, context.DeadlineExceeded)
+	a.So(batch, ShouldBeNil)
+	q.Add(7)
+	batch, err = q.NextBatchContext(ctx, 3, time.Hour)
+	a.So(err, ShouldBeNil)
+	a.So(batch, ShouldResemble, []interface{}{7})
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		q.Destroy()
+	}()
+	a.So(q.NextBatch(3, time.Hour), ShouldBeNil)
+}
+
+func TestWorkers(t *testing.T) {
+	a := New(t)
+
+	q := NewSimple()
+
+	var (
+		mu        sync.Mutex
+		processed []interface{}
+		batches   int
+	)
+	w := StartWorkers(q, 3, 10, time.Millisecond, func(batch []interface{}) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, batch...)
+		batches++
+	})
+	for i := 0; i < 100; i++ {
+		q.Add(i)
+	}
+
+	// The items that are left are processed before the workers stop
+	a.So(w.Stop(context.Background()), ShouldBeNil)
+	a.So(q.IsEmpty(), ShouldBeTrue)
+	mu.Lock()
+	a.So(processed, ShouldHaveLength, 100)
+	a.So(batches, ShouldBeLessThan, 100)
+	mu.Unlock()
+
+	// Stop gives up when the context is done
+	q = NewSimple()
+	release := make(chan struct{})
+	w = StartWorkers(q, 1, 10, 0, func(batch []interface{}) { <-release })
+	q.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	a.So(w.Stop(ctx), ShouldResemble, context.DeadlineExceeded)
+	close(release)
+	a.So(w.Stop(context.Background()), ShouldBeNil)
+}
@@ -0,0 +1,163 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// NewTokenBucket returns a new token bucket limiter that allows bursts of up to burst events, and refills one event
+// every interval. Unlike the limiter returned by NewLimiter, it does not allow bursts at the edges of the window.
+func NewTokenBucket(burst uint64, interval time.Duration) Limiter {
+	return &tokenBucket{
+		burst:    float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+	}
+}
+
+type tokenBucket struct {
+	burst    float64
+	interval time.Duration
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens for the time since the last refill, b.mu must be locked
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		if !b.last.IsZero() {
+			b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+			if b.tokens > b.burst {
+				b.tokens = b.burst
+			}
+		}
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time, n uint64) (Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	allowed := b.tokens >= float64(n)
+	if allowed {
+		b.tokens -= float64(n)
+	}
+	return bucketResult(now, allowed, b.tokens, b.burst, b.interval, n), nil
+}
+
+// bucketResult returns the Result of taking n tokens from a bucket that has the given tokens left
+func bucketResult(now time.Time, allowed bool, tokens, burst float64, interval time.Duration, n uint64) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: uint64(math.Floor(tokens)),
+		Reset:     now.Add(time.Duration(math.Ceil((burst - tokens) * float64(interval)))),
+	}
+	if !allowed {
+		res.RetryAfter = InfDuration
+		if float64(n) <= burst {
+			res.RetryAfter = time.Duration(math.Ceil((float64(n) - tokens) * float64(interval)))
+		}
+	}
+	return res
+}
+
+func (b *tokenBucket) Limit() (bool, error) {
+	res, err := b.Allow(1)
+	return !res.Allowed, err
+}
+
+func (b *tokenBucket) Allow(n uint64) (Result, error) {
+	return b.allow(time.Now(), n)
+}
+
+// NewRedisTokenBucket returns a new redis-based token bucket limiter (see NewTokenBucket)
+func NewRedisTokenBucket(client *redis.Client, key string, burst uint64, interval time.Duration) Limiter {
+	return &redisTokenBucket{
+		client:   client,
+		key:      key,
+		burst:    burst,
+		interval: interval,
+	}
+}
+
+type redisTokenBucket struct {
+	client   *redis.Client
+	key      string
+	burst    uint64
+	interval time.Duration
+}
+
+// takeScript takes ARGV[4] tokens from the bucket in KEYS[1] at time ARGV[3] (in microseconds), with burst ARGV[1]
+// and interval ARGV[2] (in microseconds). It returns 1 if the tokens were taken (0 otherwise) and the tokens that are
+// left as a string.
+//
+// The time is stored as the string that was passed, because Lua numbers lose precision when they are converted to
+// strings.
+var takeScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local events = tonumber(ARGV[4])
+local state = redis.call("HMGET", KEYS[1], "tokens", "time")
+local tokens = tonumber(state[1]) or burst
+local last = state[2]
+if not last or now > tonumber(last) then
+	if last then
+		tokens = math.min(burst, tokens + (now - tonumber(last)) / interval)
+	end
+	last = ARGV[3]
+end
+local taken = 0
+if tokens >= events then
+	tokens = tokens - events
+	taken = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tokens, "time", last)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * interval / 1000) + 1)
+return {taken, tostring(tokens)}
+`)
+
+func (b *redisTokenBucket) allow(now time.Time, n uint64) (res Result, err error) {
+	cmd := takeScript.Run(b.client, []string{b.key},
+		b.burst,
+		int64(b.interval/time.Microsecond),
+		strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10),
+		n,
+	)
+	if err = cmd.Err(); err != nil {
+		return res, err
+	}
+	reply, ok := cmd.Val().([]interface{})
+	if !ok || len(reply) != 2 {
+		return res, fmt.Errorf("rate: unexpected reply %v", cmd.Val())
+	}
+	taken, _ := reply[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(reply[1]), 64)
+	if err != nil {
+		return res, err
+	}
+	return bucketResult(now, taken == 1, tokens, float64(b.burst), b.interval, n), nil
+}
+
+func (b *redisTokenBucket) Limit() (bool, error) {
+	res, err := b.Allow(1)
+	if err != nil {
+		return true, err
+	}
+	return !res.Allowed, nil
+}
+
+func (b *redisTokenBucket) Allow(n uint64) (Result, error) {
+	return b.allow(time.Now(), n)
+}
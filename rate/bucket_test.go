@@ -0,0 +1,87 @@
+// Copyright © 2017 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenBucket(t *testing.T) {
+	type bucket interface {
+		Limiter
+		allow(now time.Time, n uint64) (Result, error)
+	}
+	buckets := map[string]func() bucket{
+		"*tokenBucket": func() bucket { return NewTokenBucket(10, time.Second).(*tokenBucket) },
+		"*redisTokenBucket": func() bucket {
+			return NewRedisTokenBucket(getRedisClient(), getKey(), 10, time.Second).(*redisTokenBucket)
+		},
+	}
+
+	defer func() {
+		for key := 1; key <= keys; key++ {
+			getRedisClient().Del(fmt.Sprintf("test-counter:%d", key))
+		}
+	}()
+
+	for typ, bucketFunc := range buckets {
+		Convey(fmt.Sprintf("Given a new %s", typ), t, func(c C) {
+			b := bucketFunc()
+			now := time.Now()
+			Convey("The first 10 events should be allowed", func() {
+				for i := 1; i <= 10; i++ {
+					res, err := b.allow(now, 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeTrue)
+					So(res.Remaining, ShouldEqual, 10-i)
+				}
+				Convey("The next event should not be allowed", func() {
+					res, err := b.allow(now, 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+					So(res.Remaining, ShouldEqual, 0)
+					So(res.RetryAfter, ShouldEqual, time.Second)
+					So(res.Reset, ShouldEqual, now.Add(10*time.Second))
+				})
+				Convey("One event should be allowed after each second", func() {
+					res, err := b.allow(now.Add(500*time.Millisecond), 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+					So(res.RetryAfter, ShouldEqual, 500*time.Millisecond)
+					res, err = b.allow(now.Add(time.Second), 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeTrue)
+					So(res.RetryAfter, ShouldEqual, 0)
+					res, err = b.allow(now.Add(time.Second), 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+				})
+				Convey("The bucket should not refill beyond the burst", func() {
+					res, err := b.allow(now.Add(time.Hour), 11)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+					So(res.Remaining, ShouldEqual, 10)
+					So(res.RetryAfter, ShouldEqual, InfDuration)
+					res, err = b.allow(now.Add(time.Hour), 10)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeTrue)
+				})
+			})
+			Convey("Limit() should limit after the burst", func() {
+				for i := 1; i <= 10; i++ {
+					limit, err := b.Limit()
+					So(err, ShouldBeNil)
+					So(limit, ShouldBeFalse)
+				}
+				limit, err := b.Limit()
+				So(err, ShouldBeNil)
+				So(limit, ShouldBeTrue)
+			})
+		})
+	}
+}
@@ -10,6 +10,7 @@
 package rate
 
 import (
+	"math"
 	"strconv"
 	"sync"
 	"time"
@@ -81,6 +82,11 @@ func (c *counter) Add(now time.Time, events uint64) error {
 }
 
 func (c *counter) Get(now time.Time, past time.Duration) (events uint64, err error) {
+	buckets, _, err := c.history(now, past)
+	return sum(buckets), err
+}
+
+func (c *counter) history(now time.Time, past time.Duration) (buckets []uint64, bucketSize time.Duration, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if past > c.retention || past == 0 {
@@ -88,9 +94,22 @@ func (c *counter) Get(now time.Time, past time.Duration) (events uint64, err err
 	}
 	c.expire(now)
 	for t := now; t.After(now.Add(-1 * past)); t = t.Add(-1 * c.bucketSize) {
-		events += c.buckets[c.bucket(t)]
+		buckets = append(buckets, c.buckets[c.bucket(t)])
 	}
-	return events, nil
+	return buckets, c.bucketSize, nil
+}
+
+// history is implemented by Counters that can return the events in each of their buckets
+type history interface {
+	// history returns the events in the buckets of the past duration, starting with the bucket of now
+	history(now time.Time, past time.Duration) (buckets []uint64, bucketSize time.Duration, err error)
+}
+
+func sum(buckets []uint64) (events uint64) {
+	for _, bucket := range buckets {
+		events += bucket
+	}
+	return events
 }
 
 // NewRedisCounter returns a new redis-based counter
@@ -133,6 +152,11 @@ func (c *redisCounter) Add(now time.Time, events uint64) (err error) {
 }
 
 func (c *redisCounter) Get(now time.Time, past time.Duration) (events uint64, err error) {
+	buckets, _, err := c.history(now, past)
+	return sum(buckets), err
+}
+
+func (c *redisCounter) history(now time.Time, past time.Duration) (events []uint64, bucketSize time.Duration, err error) {
 	if past > c.retention || past == 0 {
 		past = c.retention
 	}
@@ -141,25 +165,48 @@ func (c *redisCounter) Get(now time.Time, past time.Duration) (events uint64, er
 	buckets := pipe.HMGet(c.key, c.redisBuckets(now.Add(-1*past), now)...)
 	_, err = pipe.Exec()
 	if err != nil {
-		return events, err
+		return events, c.bucketSize, err
 	}
 	res, err := buckets.Result()
-	for _, bucket := range res {
+	events = make([]uint64, len(res))
+	for i, bucket := range res {
 		if bucket == nil {
 			continue
 		}
 		if bucket, ok := bucket.(string); ok {
-			if i, err := strconv.ParseUint(string(bucket), 10, 64); err == nil {
-				events += i
+			if n, err := strconv.ParseUint(string(bucket), 10, 64); err == nil {
+				events[len(res)-1-i] = n // the buckets are returned starting with the oldest
 			}
 		}
 	}
-	return events, err
+	return events, c.bucketSize, err
 }
 
 // Limiter limits events
 type Limiter interface {
 	Limit() (limited bool, err error)
+
+	// Allow adds n events if they are allowed. The Result can be used to populate headers such as Retry-After and
+	// X-RateLimit-Remaining.
+	Allow(n uint64) (Result, error)
+}
+
+// InfDuration is the RetryAfter of events that will never be allowed, because they exceed the limit
+const InfDuration = time.Duration(math.MaxInt64)
+
+// Result of Limiter.Allow
+type Result struct {
+	// Allowed is true if the events were allowed
+	Allowed bool
+
+	// Remaining is the number of events that are allowed after this call
+	Remaining uint64
+
+	// Reset is the time at which the limiter allows the maximum number of events again
+	Reset time.Time
+
+	// RetryAfter is the time to wait before the events would be allowed, 0 if the events were allowed
+	RetryAfter time.Duration
 }
 
 // NewLimiter returns a new limiter
@@ -178,13 +225,72 @@ type limiter struct {
 }
 
 func (l *limiter) Limit() (bool, error) {
-	now := time.Now()
-	events, err := l.Get(now, l.duration)
+	res, err := l.Allow(1)
+	return !res.Allowed, err
+}
+
+func (l *limiter) Allow(n uint64) (Result, error) {
+	return l.allow(time.Now(), n)
+}
+
+func (l *limiter) allow(now time.Time, n uint64) (res Result, err error) {
+	var (
+		buckets    []uint64
+		bucketSize time.Duration
+		offset     time.Duration
+	)
+	if h, ok := l.Counter.(history); ok {
+		buckets, bucketSize, err = h.history(now, l.duration)
+		offset = time.Duration(now.UnixNano() % int64(bucketSize))
+	} else {
+		// Without the buckets, we assume that all events leave the window at the end of the duration
+		var events uint64
+		events, err = l.Get(now, l.duration)
+		buckets, bucketSize = []uint64{events}, l.duration
+	}
 	if err != nil {
-		return true, err
+		return res, err
+	}
+
+	// Bucket i leaves the window after (len(buckets) - i) * bucketSize - offset
+	leaves := func(i int) time.Duration {
+		return time.Duration(len(buckets)-i)*bucketSize - offset
+	}
+
+	events := sum(buckets)
+	res.Reset = now
+	for i, bucket := range buckets {
+		if bucket > 0 {
+			res.Reset = now.Add(leaves(i))
+			break
+		}
 	}
-	if events >= l.limit {
-		return true, nil
+
+	if events+n <= l.limit {
+		if err = l.Add(now, n); err != nil {
+			return res, err
+		}
+		res.Allowed = true
+		res.Remaining = l.limit - events - n
+		if n > 0 && res.Reset.Equal(now) {
+			res.Reset = now.Add(leaves(0))
+		}
+		return res, nil
+	}
+
+	if events < l.limit {
+		res.Remaining = l.limit - events
+	}
+	res.RetryAfter = InfDuration
+	if n > l.limit {
+		return res, nil
+	}
+	for i := len(buckets) - 1; i >= 0; i-- {
+		events -= buckets[i]
+		if events+n <= l.limit {
+			res.RetryAfter = leaves(i)
+			break
+		}
 	}
-	return false, l.Add(now, 1)
+	return res, nil
 }
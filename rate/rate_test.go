@@ -117,3 +117,46 @@ func BenchmarkCounter(b *testing.B) {
 		l.Get(t, 10*time.Second)
 	}
 }
+
+func TestLimiterAllow(t *testing.T) {
+	Convey("Given a new Limiter", t, func(c C) {
+		retention := 10 * time.Second
+		l := NewLimiter(NewCounter(time.Second, retention), retention, 10).(*limiter)
+		now := time.Unix(1000, int64(500*time.Millisecond))
+		Convey("When allowing 4 events", func() {
+			res, err := l.allow(now, 4)
+			So(err, ShouldBeNil)
+			So(res.Allowed, ShouldBeTrue)
+			So(res.Remaining, ShouldEqual, 6)
+			So(res.RetryAfter, ShouldEqual, 0)
+			So(res.Reset, ShouldEqual, now.Add(9500*time.Millisecond))
+			Convey("And allowing 6 events 3 seconds later", func() {
+				res, err := l.allow(now.Add(3*time.Second), 6)
+				So(err, ShouldBeNil)
+				So(res.Allowed, ShouldBeTrue)
+				So(res.Remaining, ShouldEqual, 0)
+				Convey("Then the next event should wait for the first 4 events to leave the window", func() {
+					res, err := l.allow(now.Add(3*time.Second), 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+					So(res.Remaining, ShouldEqual, 0)
+					So(res.RetryAfter, ShouldEqual, 6500*time.Millisecond)
+					So(res.Reset, ShouldEqual, now.Add(12500*time.Millisecond))
+
+					res, err = l.allow(now.Add(9400*time.Millisecond), 1)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+					res, err = l.allow(now.Add(9500*time.Millisecond), 4)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeTrue)
+				})
+				Convey("Then more events than the limit should never be allowed", func() {
+					res, err := l.allow(now.Add(3*time.Second), 11)
+					So(err, ShouldBeNil)
+					So(res.Allowed, ShouldBeFalse)
+					So(res.RetryAfter, ShouldEqual, InfDuration)
+				})
+			})
+		})
+	})
+}